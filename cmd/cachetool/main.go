@@ -0,0 +1,102 @@
+// cachetool inspects and maintains the redis keyspace used by pkg/cache
+//
+// it connects the same way as the cache package: directly when REDIS_ADDR, REDIS_HOSTS
+// or REDIS_SENTINELS are set, otherwise by finding the Memorystore instance
+//
+//	cachetool scan -pattern 'orders:*' -limit 100
+//	cachetool stats -pattern 'orders:*'
+//	cachetool export -pattern 'orders:*' -file orders.jsonl
+//	cachetool import -file orders.jsonl -replace
+//	cachetool delete -pattern 'orders:v1:*' -batch 500 -pause 10ms -dry-run
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mousybusiness/googlecloudgo/pkg/cache"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd := os.Args[1]
+	switch cmd {
+	case "scan", "stats", "export", "import", "delete":
+	default:
+		usage()
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	pattern := fs.String("pattern", "*", "key pattern as accepted by SCAN MATCH")
+	limit := fs.Int("limit", 0, "maximum keys to list, 0 for no limit")
+	file := fs.String("file", "", "file to export to or import from, defaults to stdout/stdin")
+	replace := fs.Bool("replace", false, "overwrite existing keys on import")
+	batch := fs.Int("batch", 0, "keys unlinked per command")
+	pause := fs.Duration("pause", 0, "pause between delete batches")
+	dryRun := fs.Bool("dry-run", false, "count the keys which would be deleted")
+	all := fs.Bool("all", false, "allow deleting with a pattern without a literal prefix e.g. *")
+	fs.Parse(os.Args[2:])
+
+	cache.ValidateMemoryStoreAndCreatePool()
+	if !cache.Available() {
+		log.Fatalln("redis isn't reachable")
+	}
+
+	switch cmd {
+	case "scan":
+		keys, err := cache.ScanKeys(*pattern, *limit)
+		check(err)
+		for _, k := range keys {
+			fmt.Println(k)
+		}
+	case "stats":
+		stats, err := cache.Stats(*pattern)
+		check(err)
+		b, err := json.MarshalIndent(stats, "", "  ")
+		check(err)
+		fmt.Println(string(b))
+	case "export":
+		var w io.Writer = os.Stdout
+		if *file != "" {
+			f, err := os.Create(*file)
+			check(err)
+			defer f.Close()
+			w = f
+		}
+		n, err := cache.ExportKeys(*pattern, w)
+		check(err)
+		log.Println("exported", n, "keys")
+	case "import":
+		var r io.Reader = os.Stdin
+		if *file != "" {
+			f, err := os.Open(*file)
+			check(err)
+			defer f.Close()
+			r = f
+		}
+		n, err := cache.ImportKeys(r, *replace)
+		check(err)
+		log.Println("imported", n, "keys")
+	case "delete":
+		n, err := cache.DeleteKeys(*pattern, cache.DeleteOptions{BatchSize: *batch, Pause: *pause, DryRun: *dryRun, AllowAll: *all})
+		check(err)
+		fmt.Println(n)
+	}
+}
+
+func check(err error) {
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: cachetool scan|stats|export|import|delete [flags]")
+	os.Exit(2)
+}
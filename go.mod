@@ -3,16 +3,17 @@ module github.com/mousybusiness/googlecloudgo
 go 1.15
 
 require (
-	cloud.google.com/go v0.73.0
-	cloud.google.com/go/bigquery v1.14.0
+	cloud.google.com/go/bigquery v1.42.0
 	cloud.google.com/go/datastore v1.1.0
-	cloud.google.com/go/firestore v1.4.0 // indirect
+	cloud.google.com/go/redis v1.10.0
+	cloud.google.com/go/secretmanager v1.8.0
 	firebase.google.com/go v3.13.0+incompatible
 	github.com/gin-gonic/gin v1.6.3
 	github.com/gomodule/redigo v1.8.3
 	github.com/mousybusiness/go-web v0.2.2
 	github.com/pkg/errors v0.9.1
-	google.golang.org/api v0.36.0
-	google.golang.org/genproto v0.0.0-20201209185603-f92720507ed4
-	google.golang.org/grpc v1.34.0 // indirect
+	google.golang.org/api v0.102.0
+	google.golang.org/genproto v0.0.0-20221027153422-115e99e71e1c
+	google.golang.org/grpc v1.50.1
+	google.golang.org/protobuf v1.28.1
 )
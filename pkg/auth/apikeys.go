@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/ds"
+	errs "github.com/pkg/errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	APIKeyKind       = "APIKey"
+	APIKeyContextVal = "API_KEY"
+
+	apiKeyIDBytes       = 9
+	apiKeySecretBytes   = 32
+	defaultAPIKeyCache  = time.Minute
+	apiKeyScopeWildcard = "*"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrAPIKeyRevoked = errors.New("api key revoked")
+	ErrAPIKeyExpired = errors.New("api key expired")
+)
+
+// APIKeyRecord is the stored form of a key, only a hash of the secret is kept
+type APIKeyRecord struct {
+	ID        string    `datastore:"-" json:"id"`
+	Owner     string    `json:"owner"`
+	Scopes    []string  `json:"scopes"`
+	Hash      []byte    `datastore:",noindex" json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"` // zero never expires
+	Revoked   bool      `json:"revoked"`
+}
+
+// HasScope reports whether the key grants scope, "*" grants every scope
+func (k *APIKeyRecord) HasScope(scope string) bool {
+	return contains(k.Scopes, scope) || contains(k.Scopes, apiKeyScopeWildcard)
+}
+
+func (k *APIKeyRecord) GetKind() string {
+	return APIKeyKind
+}
+
+func (k *APIKeyRecord) GetValue() interface{} {
+	return k
+}
+
+// APIKeyStore creates and verifies API keys stored in Datastore
+// keys are given to clients as id.secret, the id is the entity name so verification is a single lookup
+type APIKeyStore struct {
+	CacheTTL time.Duration // how long verified keys are cached, revocations on other instances apply after this
+
+	client ds.DatastoreClient
+	mu     sync.Mutex
+	cache  map[string]cachedAPIKey
+	now    func() time.Time
+}
+
+type cachedAPIKey struct {
+	key     *APIKeyRecord
+	expires time.Time
+}
+
+func NewAPIKeyStore(client ds.DatastoreClient) *APIKeyStore {
+	return &APIKeyStore{
+		CacheTTL: defaultAPIKeyCache,
+		client:   client,
+		cache:    map[string]cachedAPIKey{},
+		now:      time.Now,
+	}
+}
+
+// Create stores a new key for owner, the returned plaintext key can't be recovered later
+func (s *APIKeyStore) Create(ctx context.Context, owner string, scopes []string, expires time.Time) (string, *APIKeyRecord, error) {
+	id, err := randomString(apiKeyIDBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(apiKeySecretBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &APIKeyRecord{
+		ID:        id,
+		Owner:     owner,
+		Scopes:    scopes,
+		Hash:      hashSecret(secret),
+		CreatedAt: s.now(),
+		ExpiresAt: expires,
+	}
+	if _, err := s.client.CreateNamed(ctx, id, nil, key); err != nil {
+		return "", nil, errs.Wrap(err, "failed to store api key")
+	}
+
+	log.Println("created api key", id, "for", owner)
+	Audit(ctx, AuditEvent{Action: ActionCreateAPIKey, Outcome: OutcomeSuccess, Principal: owner, Target: id})
+	return id + "." + secret, key, nil
+}
+
+// Rotate creates a replacement for key id with the same owner, scopes and expiry
+// the old key stays valid for grace so clients can switch over, 0 revokes it immediately
+func (s *APIKeyStore) Rotate(ctx context.Context, id string, grace time.Duration) (string, *APIKeyRecord, error) {
+	old, err := s.get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	// rotating mustn't bring a dead key back to life
+	if old.Revoked {
+		return "", nil, ErrAPIKeyRevoked
+	}
+	if !old.ExpiresAt.IsZero() && s.now().After(old.ExpiresAt) {
+		return "", nil, ErrAPIKeyExpired
+	}
+
+	plaintext, key, err := s.Create(ctx, old.Owner, old.Scopes, old.ExpiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if grace <= 0 {
+		old.Revoked = true
+	} else if until := s.now().Add(grace); old.ExpiresAt.IsZero() || until.Before(old.ExpiresAt) {
+		old.ExpiresAt = until
+	}
+	if err := s.put(ctx, old); err != nil {
+		return "", nil, err
+	}
+
+	log.Println("rotated api key", id, "to", key.ID)
+	Audit(ctx, AuditEvent{Action: ActionRotateAPIKey, Outcome: OutcomeSuccess, Principal: old.Owner, Target: id, Reason: "replaced by " + key.ID})
+	return plaintext, key, nil
+}
+
+// Revoke disables key id, the entity is kept for auditing
+func (s *APIKeyStore) Revoke(ctx context.Context, id string) error {
+	key, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	key.Revoked = true
+	if err := s.put(ctx, key); err != nil {
+		return err
+	}
+
+	log.Println("revoked api key", id)
+	Audit(ctx, AuditEvent{Action: ActionRevokeAPIKey, Outcome: OutcomeSuccess, Principal: key.Owner, Target: id})
+	return nil
+}
+
+// List returns the keys of owner
+func (s *APIKeyStore) List(ctx context.Context, owner string) ([]*APIKeyRecord, error) {
+	var records []APIKeyRecord
+	dks, err := s.client.QueryProperty(ctx, APIKeyKind, "Owner", owner, &records)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to list api keys")
+	}
+
+	var keys []*APIKeyRecord
+	for i := range records {
+		records[i].ID = dks[i].Name
+		keys = append(keys, &records[i])
+	}
+	return keys, nil
+}
+
+// Verify returns the stored key matching plaintext if it's neither revoked nor expired
+func (s *APIKeyStore) Verify(ctx context.Context, plaintext string) (*APIKeyRecord, error) {
+	i := strings.Index(plaintext, ".")
+	if i <= 0 {
+		return nil, ErrInvalidAPIKey
+	}
+	id, secret := plaintext[:i], plaintext[i+1:]
+
+	key, err := s.cached(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(key.Hash, hashSecret(secret)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.Revoked {
+		return nil, ErrAPIKeyRevoked
+	}
+	if !key.ExpiresAt.IsZero() && s.now().After(key.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	return key, nil
+}
+
+func (s *APIKeyStore) cached(ctx context.Context, id string) (*APIKeyRecord, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	c, ok := s.cache[id]
+	s.mu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.key, nil
+	}
+
+	key, err := s.get(ctx, id)
+	if errs.Cause(err) == datastore.ErrNoSuchEntity {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[id] = cachedAPIKey{key: key, expires: now.Add(s.CacheTTL)}
+	s.mu.Unlock()
+	return key, nil
+}
+
+func (s *APIKeyStore) get(ctx context.Context, id string) (*APIKeyRecord, error) {
+	key := &APIKeyRecord{}
+	if err := s.client.GetNamed(ctx, id, nil, key); err != nil {
+		return nil, err
+	}
+	key.ID = id
+	return key, nil
+}
+
+func (s *APIKeyStore) put(ctx context.Context, key *APIKeyRecord) error {
+	if _, err := s.client.CreateNamed(ctx, key.ID, nil, key); err != nil {
+		return errs.Wrap(err, "failed to update api key")
+	}
+
+	s.mu.Lock()
+	delete(s.cache, key.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// Gin middleware verifying the X-API-Key header against store, the key must grant every one of scopes
+// the matched *APIKeyRecord is stored under APIKeyContextVal
+func AuthAPIKeys(store *APIKeyStore, scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		key, err := store.Verify(c, c.Request.Header.Get(apiKeyHeader))
+		if err != nil {
+			log.Println("api key rejected:", err)
+			auditRequest(c, start, AuditEvent{Strategy: StrategyAPIKey, Outcome: OutcomeFailure, Reason: err.Error()})
+			abortUnauthorized(c)
+			return
+		}
+
+		for _, scope := range scopes {
+			if !key.HasScope(scope) {
+				log.Println("api key", key.ID, "missing scope", scope)
+				auditRequest(c, start, AuditEvent{Strategy: StrategyAPIKey, Outcome: OutcomeDenied, Principal: key.ID, Reason: "missing scope " + scope})
+				abortForbidden(c)
+				return
+			}
+		}
+
+		auditRequest(c, start, AuditEvent{Strategy: StrategyAPIKey, Outcome: OutcomeSuccess, Principal: key.ID})
+
+		c.Set(APIKeyContextVal, key)
+		c.Next()
+	}
+}
+
+// secrets are 256 random bits so a fast hash is enough
+func hashSecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errs.Wrap(err, "failed to generate random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
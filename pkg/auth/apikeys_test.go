@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/ds"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// named entities and property queries only
+type fakeDatastore struct {
+	ds.DatastoreClient
+	entities map[string]APIKeyRecord
+	gets     int
+}
+
+func (f *fakeDatastore) CreateNamed(ctx context.Context, name string, parent *datastore.Key, entity ds.Entity) (*datastore.Key, error) {
+	f.entities[name] = *entity.GetValue().(*APIKeyRecord)
+	return datastore.NameKey(entity.GetKind(), name, parent), nil
+}
+
+func (f *fakeDatastore) GetNamed(ctx context.Context, name string, parent *datastore.Key, entity ds.Entity) error {
+	f.gets++
+	k, ok := f.entities[name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*entity.GetValue().(*APIKeyRecord) = k
+	return nil
+}
+
+func (f *fakeDatastore) QueryProperty(ctx context.Context, kind string, property string, value string, entitySlicePtr interface{}) ([]*datastore.Key, error) {
+	if kind != APIKeyKind || property != "Owner" {
+		return nil, errors.New("fake datastore: unsupported query")
+	}
+
+	var names []string
+	for name, k := range f.entities {
+		if k.Owner == value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	records := entitySlicePtr.(*[]APIKeyRecord)
+	var keys []*datastore.Key
+	for _, name := range names {
+		*records = append(*records, f.entities[name])
+		keys = append(keys, datastore.NameKey(kind, name, nil))
+	}
+	return keys, nil
+}
+
+func TestAPIKeyStore(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDatastore{entities: map[string]APIKeyRecord{}}
+	store := NewAPIKeyStore(fake)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	plaintext, key, err := store.Create(ctx, "billing-service", []string{"invoices:read"}, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.entities[key.ID].Hash) == plaintext {
+		t.Fatal("test failed; secret stored in plaintext")
+	}
+
+	tt := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"valid", plaintext, true},
+		{"wrong secret", key.ID + ".nope", false},
+		{"unknown id", "unknown." + plaintext, false},
+		{"no id", "secret", false},
+		{"empty", "", false},
+	}
+	for _, tc := range tt {
+		got, err := store.Verify(ctx, tc.input)
+		if (err == nil) != tc.valid {
+			t.Errorf("test failed; input: %v, wanted valid: %v, got: %v", tc.name, tc.valid, err)
+		}
+		if tc.valid && got.Owner != "billing-service" {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, "billing-service", got.Owner)
+		}
+	}
+
+	// verified keys are cached
+	gets := fake.gets
+	store.Verify(ctx, plaintext)
+	if fake.gets != gets {
+		t.Errorf("test failed; wanted cached key, got %v lookups", fake.gets-gets)
+	}
+
+	rotated, _, err := store.Rotate(ctx, key.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(ctx, plaintext); err != nil {
+		t.Errorf("test failed; wanted old key valid during grace period, got: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := store.Verify(ctx, plaintext); err != ErrAPIKeyExpired {
+		t.Errorf("test failed; wanted: %v, got: %v", ErrAPIKeyExpired, err)
+	}
+
+	newKey, err := store.Verify(ctx, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, newKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(ctx, rotated); err != ErrAPIKeyRevoked {
+		t.Errorf("test failed; wanted: %v, got: %v", ErrAPIKeyRevoked, err)
+	}
+
+	// dead keys can't be rotated into new ones
+	for _, rt := range []struct {
+		id       string
+		expected error
+	}{
+		{newKey.ID, ErrAPIKeyRevoked},
+		{key.ID, ErrAPIKeyExpired},
+	} {
+		if _, _, err := store.Rotate(ctx, rt.id, 0); err != rt.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", rt.id, rt.expected, err)
+		}
+	}
+}
+
+func TestAPIKeyStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := NewAPIKeyStore(&fakeDatastore{entities: map[string]APIKeyRecord{}})
+
+	var created []string
+	for _, owner := range []string{"billing-service", "reports-service", "billing-service"} {
+		_, key, err := store.Create(ctx, owner, []string{"invoices:read"}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner == "billing-service" {
+			created = append(created, key.ID)
+		}
+	}
+	sort.Strings(created)
+
+	tt := []struct {
+		owner    string
+		expected []string
+	}{
+		{"billing-service", created},
+		{"unknown", nil},
+	}
+	for _, tc := range tt {
+		keys, err := store.List(ctx, tc.owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, k := range keys {
+			if k.Owner != tc.owner {
+				t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.owner, tc.owner, k.Owner)
+			}
+			ids = append(ids, k.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.owner, tc.expected, ids)
+		}
+	}
+}
+
+func TestAuthAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewAPIKeyStore(&fakeDatastore{entities: map[string]APIKeyRecord{}})
+	reader, _, _ := store.Create(context.Background(), "reader", []string{"invoices:read"}, time.Time{})
+	admin, _, _ := store.Create(context.Background(), "admin", []string{"*"}, time.Time{})
+
+	r := gin.New()
+	r.POST("/invoices", AuthAPIKeys(store, "invoices:write"), func(c *gin.Context) {
+		c.String(http.StatusOK, c.MustGet(APIKeyContextVal).(*APIKeyRecord).Owner)
+	})
+
+	tt := []struct {
+		name     string
+		key      string
+		expected int
+	}{
+		{"wildcard scope", admin, http.StatusOK},
+		{"missing scope", reader, http.StatusForbidden},
+		{"invalid key", "bad.key", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodPost, "/invoices", nil)
+		req.Header.Set("X-API-Key", tc.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/bq"
+	errs "github.com/pkg/errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure" // no or invalid credentials
+	OutcomeDenied  = "denied"  // authenticated but not allowed
+
+	ActionAuthenticate = "authenticate"
+	ActionAuthorize    = "authorize"
+	ActionElevateAdmin = "elevate_admin"
+	ActionRevokeAdmin  = "revoke_admin"
+	ActionSetRoles     = "set_roles"
+	ActionCreateAPIKey = "create_api_key"
+	ActionRotateAPIKey = "rotate_api_key"
+	ActionRevokeAPIKey = "revoke_api_key"
+
+	requestIDHeader = "X-Request-Id"
+	traceHeader     = "X-Cloud-Trace-Context"
+
+	defaultAuditBatch    = 100
+	defaultAuditInterval = 5 * time.Second
+)
+
+var (
+	ErrAuditBufferFull = errors.New("audit buffer full, event dropped")
+	ErrAuditSinkClosed = errors.New("audit sink closed, event dropped")
+)
+
+// AuditEvent describes an authentication or authorization decision or a change of privileges
+type AuditEvent struct {
+	Time      time.Time `json:"time" bigquery:"time"`
+	Action    string    `json:"action" bigquery:"action"`
+	Outcome   string    `json:"outcome" bigquery:"outcome"`
+	Strategy  string    `json:"strategy,omitempty" bigquery:"strategy"`
+	Principal string    `json:"principal,omitempty" bigquery:"principal"` // uid, subject, api key id or ip
+	Target    string    `json:"target,omitempty" bigquery:"target"`       // user or key changed by the action
+	Reason    string    `json:"reason,omitempty" bigquery:"reason"`
+	ClientIP  string    `json:"clientIp,omitempty" bigquery:"client_ip"`
+	RequestID string    `json:"requestId,omitempty" bigquery:"request_id"`
+	Method    string    `json:"method,omitempty" bigquery:"method"`
+	Path      string    `json:"path,omitempty" bigquery:"path"`
+	LatencyMs int64     `json:"latencyMs,omitempty" bigquery:"latency_ms"`
+}
+
+// AuditSink receives audit events, Write must be safe for concurrent use
+type AuditSink interface {
+	Write(ctx context.Context, e AuditEvent) error
+}
+
+var (
+	auditSink AuditSink
+	auditMu   sync.RWMutex
+)
+
+// SetAuditSink enables audit events, nil disables them (the default)
+func SetAuditSink(sink AuditSink) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSink = sink
+}
+
+// Audit sends e to the configured sink, failures are logged and never block the request
+func Audit(ctx context.Context, e AuditEvent) {
+	auditMu.RLock()
+	sink := auditSink
+	auditMu.RUnlock()
+	if sink == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if err := sink.Write(ctx, e); err != nil {
+		log.Println("failed to write audit event:", err)
+	}
+}
+
+// adds the request details to e
+func auditRequest(c *gin.Context, start time.Time, e AuditEvent) {
+	// the connection's address, gin's ClientIP trusts X-Forwarded-For from anyone
+	if ip := remoteIP(c.Request); ip != nil {
+		e.ClientIP = ip.String()
+	}
+	e.RequestID = requestID(c)
+	e.Method = c.Request.Method
+	e.Path = c.Request.URL.Path
+	if !start.IsZero() {
+		e.LatencyMs = time.Since(start).Milliseconds()
+	}
+	if e.Action == "" {
+		e.Action = ActionAuthenticate
+	}
+	Audit(c, e)
+}
+
+type actorKey struct{}
+
+// WithActor records who is making changes through ctx e.g. a CLI user or job name, for audit events of
+// ElevateToAdmin, RevokeAdmin and SetUserRoles. gin contexts use the caller authenticated by the middleware
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// caller making a change, from the middleware for gin contexts or WithActor
+func actor(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok {
+		if p := GetPrincipal(c); p != nil {
+			return p.ID
+		}
+		if token, ok := contextToken(c); ok {
+			return token.UID
+		}
+		ctx = c.Request.Context()
+	}
+	a, _ := ctx.Value(actorKey{}).(string)
+	return a
+}
+
+// audits a change made by the caller of ctx, with the request details when ctx is a gin context
+func auditChange(ctx context.Context, e AuditEvent) {
+	if e.Principal == "" {
+		e.Principal = actor(ctx)
+	}
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		auditRequest(c, time.Time{}, e)
+		return
+	}
+	Audit(ctx, e)
+}
+
+func auditOutcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	if errs.Cause(err) == ErrForbidden {
+		return OutcomeDenied
+	}
+	return OutcomeFailure
+}
+
+func auditReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// X-Request-Id or the trace id of X-Cloud-Trace-Context
+func requestID(c *gin.Context) string {
+	if id := c.Request.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	trace := c.Request.Header.Get(traceHeader)
+	if i := strings.Index(trace, "/"); i > 0 {
+		return trace[:i]
+	}
+	return trace
+}
+
+// JSONSink writes one JSON object per event, on Cloud Run, App Engine and GKE stdout lines are parsed by Cloud Logging
+type JSONSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type cloudLoggingEntry struct {
+	AuditEvent
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Labels   map[string]string `json:"logging.googleapis.com/labels"`
+}
+
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// StdoutSink writes Cloud Logging structured entries to stdout
+func StdoutSink() *JSONSink {
+	return NewJSONSink(os.Stdout)
+}
+
+func (s *JSONSink) Write(ctx context.Context, e AuditEvent) error {
+	severity := "INFO"
+	if e.Outcome != OutcomeSuccess {
+		severity = "WARNING"
+	} else if e.Action != ActionAuthenticate && e.Action != ActionAuthorize {
+		severity = "NOTICE"
+	}
+
+	b, err := json.Marshal(cloudLoggingEntry{
+		AuditEvent: e,
+		Severity:   severity,
+		Message:    strings.TrimSpace(e.Action + " " + e.Outcome + " " + e.Principal),
+		Labels:     map[string]string{"type": "auth_audit"},
+	})
+	if err != nil {
+		return errs.Wrap(err, "failed to serialise audit event")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// BigQuerySink streams events into a BigQuery table in batches
+// the table needs columns matching the bigquery tags of AuditEvent, call Close on shutdown to flush
+type BigQuerySink struct {
+	Dataset string
+	Table   string
+
+	mu       sync.RWMutex
+	closed   bool
+	events   chan AuditEvent
+	done     chan struct{}
+	inserter rowInserter
+	client   io.Closer
+	release  sync.Once
+}
+
+// the part of bigquery.Inserter used by BigQuerySink
+type rowInserter interface {
+	Put(ctx context.Context, src interface{}) error
+}
+
+// NewBigQuerySink creates one BigQuery client which is used for every flush until Close
+func NewBigQuerySink(ctx context.Context, dataset string, table string) (*BigQuerySink, error) {
+	client, err := bq.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BigQuerySink{
+		Dataset:  dataset,
+		Table:    table,
+		events:   make(chan AuditEvent, 10*defaultAuditBatch),
+		done:     make(chan struct{}),
+		inserter: client.Dataset(dataset).Table(table).Inserter(),
+		client:   client,
+	}
+	go s.run(defaultAuditBatch, defaultAuditInterval)
+	return s, nil
+}
+
+// Write queues e, events are dropped rather than blocking when the buffer is full
+// returns ErrAuditSinkClosed after Close
+func (s *BigQuerySink) Write(ctx context.Context, e AuditEvent) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrAuditSinkClosed
+	}
+
+	select {
+	case s.events <- e:
+		return nil
+	default:
+		return ErrAuditBufferFull
+	}
+}
+
+// Close flushes queued events and closes the BigQuery client, the sink can't be written to afterwards
+// safe to call more than once
+func (s *BigQuerySink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mu.Unlock()
+
+	<-s.done
+
+	var err error
+	s.release.Do(func() {
+		err = s.client.Close()
+	})
+	return err
+}
+
+func (s *BigQuerySink) run(batchSize int, interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var batch []AuditEvent
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.inserter.Put(ctx, batch); err != nil {
+			log.Println("failed to insert", len(batch), "audit events:", err)
+		}
+		cancel()
+		batch = nil
+	}
+
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
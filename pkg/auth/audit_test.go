@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditJSONSink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	SetAuditSink(NewJSONSink(&buf))
+	defer SetAuditSink(nil)
+
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", nil)
+	valid, _ := client.Mint("user-1", nil)
+	if err := ElevateToAdmin(context.Background(), client, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET("/", AuthJWT(client), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, header := range []string{"Bearer " + valid, "Bearer nope"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", header)
+		req.Header.Set("X-Cloud-Trace-Context", "abc123/1;o=1")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("test failed; wanted: %v events, got: %v", 3, len(lines))
+	}
+
+	expected := []struct {
+		action   string
+		outcome  string
+		severity string
+	}{
+		{ActionElevateAdmin, OutcomeSuccess, "NOTICE"},
+		{ActionAuthenticate, OutcomeSuccess, "INFO"},
+		{ActionAuthenticate, OutcomeFailure, "WARNING"},
+	}
+	for i, e := range expected {
+		var entry cloudLoggingEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Action != e.action || entry.Outcome != e.outcome || entry.Severity != e.severity {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", i, e, entry)
+		}
+		if e.action == ActionAuthenticate && (entry.RequestID != "abc123" || entry.Strategy != StrategyJWT || entry.Path != "/") {
+			t.Errorf("test failed; input: %v, wanted request details, got: %v", i, entry)
+		}
+	}
+}
+
+type fakeInserter struct {
+	inserted [][]AuditEvent
+}
+
+func (f *fakeInserter) Put(ctx context.Context, src interface{}) error {
+	f.inserted = append(f.inserted, src.([]AuditEvent))
+	return nil
+}
+
+type fakeCloser struct {
+	closes int
+}
+
+func (f *fakeCloser) Close() error {
+	f.closes++
+	return nil
+}
+
+func TestBigQuerySink(t *testing.T) {
+	inserter, client := &fakeInserter{}, &fakeCloser{}
+	s := &BigQuerySink{
+		Dataset:  "audit",
+		Table:    "events",
+		events:   make(chan AuditEvent, 10),
+		done:     make(chan struct{}),
+		inserter: inserter,
+		client:   client,
+	}
+	go s.run(2, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Write(context.Background(), AuditEvent{Action: ActionAuthenticate}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	inserted := inserter.inserted
+	if len(inserted) != 2 || len(inserted[0]) != 2 || len(inserted[1]) != 1 {
+		t.Errorf("test failed; wanted batches of 2 and 1, got: %v", inserted)
+	}
+
+	// still registered sinks mustn't panic after Close
+	if err := s.Write(context.Background(), AuditEvent{Action: ActionAuthenticate}); err != ErrAuditSinkClosed {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "write after close", ErrAuditSinkClosed, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "second close", nil, err)
+	}
+
+	// the client is shared by every flush and closed once
+	if client.closes != 1 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "client closes", 1, client.closes)
+	}
+}
+
+type recordingSink struct {
+	events []AuditEvent
+}
+
+func (s *recordingSink) Write(ctx context.Context, e AuditEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestAuditChangeActor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sink := &recordingSink{}
+	SetAuditSink(sink)
+	defer SetAuditSink(nil)
+
+	client := authtest.NewFakeClient()
+	client.CreateUser("admin-1", map[string]interface{}{"admin": true})
+	client.CreateUser("user-1", nil)
+	token, _ := client.Mint("admin-1", nil)
+
+	r := gin.New()
+	r.POST("/elevate", AuthJWT(client), func(c *gin.Context) {
+		ElevateToAdmin(c, client, "user-1")
+	})
+	req := httptest.NewRequest(http.MethodPost, "/elevate", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.RemoteAddr = "10.0.0.1:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	RevokeAdmin(WithActor(context.Background(), "ops-cli"), client, "user-1")
+
+	var changes []AuditEvent
+	for _, e := range sink.events {
+		if e.Action != ActionAuthenticate {
+			changes = append(changes, e)
+		}
+	}
+	if len(changes) != 2 {
+		t.Fatalf("test failed; wanted: %v events, got: %v", 2, changes)
+	}
+	if e := changes[0]; e.Principal != "admin-1" || e.Target != "user-1" || e.Path != "/elevate" || e.ClientIP != "10.0.0.1" {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "gin context", "admin-1 with request details", e)
+	}
+	if e := changes[1]; e.Principal != "ops-cli" || e.Action != ActionRevokeAdmin {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "WithActor", "ops-cli", e)
+	}
+}
@@ -1,13 +1,11 @@
 package auth
 
 import (
-	fbauth "firebase.google.com/go/auth"
+	"crypto/subtle"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net"
 	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -17,15 +15,21 @@ const (
 	cronExecutedHeader  = "X-Appengine-Cron"
 )
 
-// Gin middleware for JWT auth
-func AuthJWT(client *fbauth.Client) gin.HandlerFunc {
+// Gin middleware for JWT auth, by default the ID token is read from the Authorization header
+// opts enable revocation checks and reading the token or a session cookie from cookies
+func AuthJWT(client TokenVerifier, opts ...JWTOptions) gin.HandlerFunc {
+	var o JWTOptions
+	if len(opts) > 0 {
+		o = opts[0]
+		o.check(client)
+	}
+
 	return func(c *gin.Context) {
 		startTime := time.Now()
 
-		authHeader := c.Request.Header.Get(authorizationHeader)
-		token := strings.Replace(authHeader, "Bearer ", "", 1)
-		idToken, err := client.VerifyIDToken(c, token) // usually hits a local cache
+		idToken, strategy, err := o.verify(c, client)
 		if err != nil {
+			auditRequest(c, startTime, AuditEvent{Strategy: strategy, Outcome: OutcomeFailure, Reason: err.Error()})
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"code":    http.StatusUnauthorized,
 				"message": http.StatusText(http.StatusUnauthorized),
@@ -34,20 +38,23 @@ func AuthJWT(client *fbauth.Client) gin.HandlerFunc {
 		}
 
 		log.Println("Auth time:", time.Since(startTime))
+		auditRequest(c, startTime, AuditEvent{Strategy: strategy, Outcome: OutcomeSuccess, Principal: idToken.UID})
 
 		c.Set(FirebaseContextVal, idToken)
 		c.Next()
 	}
 }
 
-// API key auth middleware
+// API key auth middleware for a single shared secret, see AuthAPIKeys for per client keys
+// or AuthHMAC for signed requests which never send the secret
 
 func AuthAPIKey(secret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.Request.Header.Get(apiKeyHeader)
 
-		if key == "" || secret != key {
+		if !sharedKeyMatches(secret, key) {
 			log.Println("api key mismatch!")
+			auditRequest(c, time.Time{}, AuditEvent{Strategy: StrategySharedKey, Outcome: OutcomeFailure, Reason: "api key mismatch"})
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"code":    http.StatusUnauthorized,
 				"message": http.StatusText(http.StatusUnauthorized),
@@ -59,13 +66,19 @@ func AuthAPIKey(secret string) gin.HandlerFunc {
 	}
 }
 
-// AppEngine cron authentication
+func sharedKeyMatches(secret string, key string) bool {
+	return key != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(key)) == 1
+}
+
+// AppEngine cron authentication, only safe on App Engine Standard
+// use AuthScheduler with GoogleServiceAccount on Cloud Run and GCE
 func AuthAppEngineCron() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		cron := c.Request.Header.Get(cronExecutedHeader)
 
 		if cron != "true" {
 			log.Println("not invoked by cron - access denied")
+			auditRequest(c, time.Time{}, AuditEvent{Strategy: StrategyScheduler, Outcome: OutcomeFailure, Reason: "missing cron header"})
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"code":    http.StatusUnauthorized,
 				"message": http.StatusText(http.StatusUnauthorized),
@@ -78,40 +91,13 @@ func AuthAppEngineCron() gin.HandlerFunc {
 }
 
 func checkInternal(ip string) bool {
-	if strings.HasPrefix(ip, "127.") || strings.HasPrefix(ip, "10.") || strings.HasPrefix(ip, "192.168") {
-		return true
-	}
-
-	if strings.HasPrefix(ip, "172.") {
-		exp := regexp.MustCompile(`\d{1,3}\.(\d{1,3})\.\d{1,3}\.\d{1,3}`)
-		v := exp.ReplaceAllString(ip, `$1`)
-		atoi, err := strconv.Atoi(v)
-		if err != nil {
-			log.Println("failed to convert to int", v)
-			return false
-		}
-
-		if atoi >= 16 && atoi <= 31 {
-			return true
-		}
-	}
-
-	return false
+	return inNets(privateNets, net.ParseIP(ip))
 }
 
-// only allow internal ip ranges
+// only allow internal ip ranges (PrivateRanges), rejected requests get a 403
+// X-Forwarded-For is only read when the connection comes from an internal proxy (or the link local
+// Cloud Run front end) and is walked from the right so the first untrusted hop is the one checked
 func AuthInternalOnly() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		if !checkInternal(ip) {
-			log.Println("rejected IP:", ip)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"code":    http.StatusUnauthorized,
-				"message": "tisk tisk tisk",
-			})
-			return
-		}
-
-		c.Next()
-	}
+	f := &IPFilter{allow: privateNets, proxies: internalProxyNets, reject: abortForbidden}
+	return f.Middleware()
 }
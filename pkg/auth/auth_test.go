@@ -1,6 +1,13 @@
 package auth
 
-import "testing"
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
 
 func TestCheckInternal(t *testing.T) {
 	var tests = []struct {
@@ -19,6 +26,10 @@ func TestCheckInternal(t *testing.T) {
 		{"2.32.0.222", false},
 		{"32.18.1.23", false},
 		{"0.0.0.0", false},
+		{"::1", true},
+		{"fd12:3456::1", true},
+		{"2001:4860::1", false},
+		{"not an ip", false},
 	}
 
 	for _, test := range tests {
@@ -27,3 +38,106 @@ func TestCheckInternal(t *testing.T) {
 		}
 	}
 }
+
+func TestAuthJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := authtest.NewFakeClient()
+	valid, _ := client.Mint("user-1", map[string]interface{}{"plan": "pro"})
+	expired, _ := client.MintWithExpiry("user-1", nil, time.Now().Add(-time.Hour))
+
+	r := gin.New()
+	r.GET("/", AuthJWT(client), func(c *gin.Context) {
+		token, _ := contextToken(c)
+		c.String(http.StatusOK, token.UID+" "+token.Claims["plan"].(string))
+	})
+
+	tt := []struct {
+		name     string
+		header   string
+		expected int
+	}{
+		{"valid", "Bearer " + valid, http.StatusOK},
+		{"expired", "Bearer " + expired, http.StatusUnauthorized},
+		{"tampered", "Bearer " + valid[:len(valid)-2] + "xx", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(authorizationHeader, tc.header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("%s failed; wanted: %d, got: %d", tc.name, tc.expected, w.Code)
+		}
+		if tc.expected == http.StatusOK && w.Body.String() != "user-1 pro" {
+			t.Errorf("%s failed; unexpected body: %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+func TestAuthInternalOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", AuthInternalOnly(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tt := []struct {
+		name      string
+		remote    string
+		forwarded string
+		expected  int
+	}{
+		{"internal", "10.1.2.3:1234", "", http.StatusOK},
+		{"external", "8.8.8.8:1234", "", http.StatusForbidden},
+		{"spoofed forwarded for", "8.8.8.8:1234", "10.0.0.1", http.StatusForbidden},
+		{"external through internal proxy", "10.1.2.3:1234", "8.8.8.8", http.StatusForbidden},
+		{"spoofed through internal proxy", "10.1.2.3:1234", "10.0.0.1, 8.8.8.8", http.StatusForbidden},
+		{"internal through internal proxy", "10.1.2.3:1234", "10.0.0.5", http.StatusOK},
+		{"external through cloud run", "169.254.1.1:1234", "8.8.8.8", http.StatusForbidden},
+		{"internal through cloud run", "169.254.1.1:1234", "10.0.0.5", http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := authtest.NewFakeClient()
+
+	tt := []struct {
+		name     string
+		claims   map[string]interface{}
+		expected int
+	}{
+		{"admin claim", map[string]interface{}{"admin": true}, 0},
+		{"admin role", map[string]interface{}{RolesClaim: []string{AdminRole}}, 0},
+		{"admin false", map[string]interface{}{"admin": false}, http.StatusForbidden},
+		{"admin not a bool", map[string]interface{}{"admin": "yes"}, http.StatusForbidden},
+		{"no claims", nil, http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		token, _ := client.Mint("user-1", tc.claims)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+
+		if code, _, _ := VerifyToken(c, client); code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, code)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// The authtest pkg provides an in memory Firebase auth client for unit testing
+// handlers which use the pkg/auth middleware without Firebase credentials
+package authtest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	fbauth "firebase.google.com/go/auth"
+	"fmt"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/internal/autherr"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProjectID    = "test-project"
+	issuerPrefix        = "https://securetoken.google.com/"
+	sessionIssuerPrefix = "https://session.firebase.google.com/"
+	tokenLifetime       = time.Hour
+)
+
+// the same values as auth.ErrUserNotFound, auth.ErrTokenRevoked and auth.ErrUserDisabled
+var (
+	ErrUserNotFound = autherr.ErrUserNotFound
+	ErrTokenRevoked = autherr.ErrTokenRevoked
+	ErrUserDisabled = autherr.ErrUserDisabled
+)
+
+// FakeClient mints and verifies locally signed ID tokens and keeps users in memory
+// it satisfies auth.TokenVerifier, auth.UserManager, auth.AuthClient, auth.RevocationVerifier,
+// auth.SessionCookieVerifier and auth.SessionCookieIssuer
+type FakeClient struct {
+	ProjectID string
+	TenantID  string // set to act as a tenant client, tokens carry and must match firebase.tenant
+	Now       func() time.Time
+
+	mu    sync.Mutex
+	key   []byte
+	users map[string]*fbauth.UserRecord
+}
+
+func NewFakeClient() *FakeClient {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return &FakeClient{
+		ProjectID: DefaultProjectID,
+		Now:       time.Now,
+		key:       key,
+		users:     map[string]*fbauth.UserRecord{},
+	}
+}
+
+// CreateUser adds a user with the given custom claims
+func (f *FakeClient) CreateUser(uid string, customClaims map[string]interface{}) *fbauth.UserRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u := &fbauth.UserRecord{
+		UserInfo:     &fbauth.UserInfo{UID: uid, ProviderID: "firebase"},
+		CustomClaims: customClaims,
+		UserMetadata: &fbauth.UserMetadata{CreationTimestamp: f.Now().UnixNano() / int64(time.Millisecond)},
+	}
+	f.users[uid] = u
+	return u
+}
+
+// Mint returns a signed ID token for uid containing the user's custom claims (if the user exists) and claims
+func (f *FakeClient) Mint(uid string, claims map[string]interface{}) (string, error) {
+	return f.MintWithExpiry(uid, claims, f.Now().Add(tokenLifetime))
+}
+
+// MintWithExpiry returns a signed ID token for uid which expires at expires
+func (f *FakeClient) MintWithExpiry(uid string, claims map[string]interface{}, expires time.Time) (string, error) {
+	return f.mint(issuerPrefix, uid, claims, f.Now().Unix(), expires)
+}
+
+func (f *FakeClient) mint(issuer string, uid string, claims map[string]interface{}, authTime int64, expires time.Time) (string, error) {
+	now := f.Now()
+	payload := map[string]interface{}{}
+
+	f.mu.Lock()
+	if u, ok := f.users[uid]; ok {
+		for k, v := range u.CustomClaims {
+			payload[k] = v
+		}
+	}
+	f.mu.Unlock()
+
+	for k, v := range claims {
+		payload[k] = v
+	}
+	payload["iss"] = issuer + f.ProjectID
+	payload["aud"] = f.ProjectID
+	payload["sub"] = uid
+	payload["iat"] = now.Unix()
+	payload["auth_time"] = authTime
+	payload["exp"] = expires.Unix()
+	if _, ok := payload["firebase"]; !ok {
+		firebase := map[string]interface{}{"sign_in_provider": "custom"}
+		if f.TenantID != "" {
+			firebase["tenant"] = f.TenantID
+		}
+		payload["firebase"] = firebase
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encode(header) + "." + encode(body)
+	return unsigned + "." + encode(f.sign(unsigned)), nil
+}
+
+// VerifyIDToken checks the signature, audience, issuer and expiry of a token minted by this client
+func (f *FakeClient) VerifyIDToken(ctx context.Context, idToken string) (*fbauth.Token, error) {
+	return f.verify(issuerPrefix, idToken)
+}
+
+// VerifyIDTokenAndCheckRevoked also rejects tokens of disabled users or issued before RevokeRefreshTokens
+func (f *FakeClient) VerifyIDTokenAndCheckRevoked(ctx context.Context, idToken string) (*fbauth.Token, error) {
+	token, err := f.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	return token, f.checkRevoked(token)
+}
+
+// SessionCookie exchanges an ID token minted by this client for a session cookie
+func (f *FakeClient) SessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error) {
+	token, err := f.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+	return f.mint(sessionIssuerPrefix, token.UID, nil, token.AuthTime, f.Now().Add(expiresIn))
+}
+
+func (f *FakeClient) VerifySessionCookie(ctx context.Context, sessionCookie string) (*fbauth.Token, error) {
+	return f.verify(sessionIssuerPrefix, sessionCookie)
+}
+
+func (f *FakeClient) VerifySessionCookieAndCheckRevoked(ctx context.Context, sessionCookie string) (*fbauth.Token, error) {
+	token, err := f.VerifySessionCookie(ctx, sessionCookie)
+	if err != nil {
+		return nil, err
+	}
+	return token, f.checkRevoked(token)
+}
+
+func (f *FakeClient) checkRevoked(token *fbauth.Token) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[token.UID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Disabled {
+		return ErrUserDisabled
+	}
+	if token.AuthTime*1000 < u.TokensValidAfterMillis {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+func (f *FakeClient) verify(issuer string, idToken string) (*fbauth.Token, error) {
+	segments := strings.Split(idToken, ".")
+	if len(segments) != 3 {
+		return nil, errors.New("incorrect number of segments")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil || !hmac.Equal(sig, f.sign(segments[0]+"."+segments[1])) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var token fbauth.Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.Audience != f.ProjectID || token.Issuer != issuer+f.ProjectID {
+		return nil, fmt.Errorf("token issued for a different project: %s", token.Audience)
+	}
+	if token.Subject == "" {
+		return nil, errors.New("token has empty 'sub' (subject) claim")
+	}
+	if token.Firebase.Tenant != f.TenantID {
+		return nil, fmt.Errorf("invalid tenant id: %q", token.Firebase.Tenant)
+	}
+	if token.Expires < f.Now().Unix() {
+		return nil, fmt.Errorf("token has expired at: %d", token.Expires)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, err
+	}
+	for _, standard := range []string{"iss", "aud", "exp", "iat", "sub", "uid"} {
+		delete(claims, standard)
+	}
+
+	token.UID = token.Subject
+	token.Claims = claims
+	return &token, nil
+}
+
+// GetUser returns a copy of the user, changes to it aren't stored
+func (f *FakeClient) GetUser(ctx context.Context, uid string) (*fbauth.UserRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[uid]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return copyUser(u), nil
+}
+
+// SetDisabled disables or enables the user, disabled users fail revocation checks
+func (f *FakeClient) SetDisabled(uid string, disabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[uid]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Disabled = disabled
+	return nil
+}
+
+func copyUser(u *fbauth.UserRecord) *fbauth.UserRecord {
+	c := *u
+	if u.UserInfo != nil {
+		info := *u.UserInfo
+		c.UserInfo = &info
+	}
+	if u.UserMetadata != nil {
+		metadata := *u.UserMetadata
+		c.UserMetadata = &metadata
+	}
+	if u.CustomClaims != nil {
+		c.CustomClaims = map[string]interface{}{}
+		for k, v := range u.CustomClaims {
+			c.CustomClaims[k] = v
+		}
+	}
+	return &c
+}
+
+func (f *FakeClient) SetCustomUserClaims(ctx context.Context, uid string, customClaims map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[uid]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.CustomClaims = customClaims
+	return nil
+}
+
+// RevokeRefreshTokens records the revocation time on the user
+func (f *FakeClient) RevokeRefreshTokens(ctx context.Context, uid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[uid]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.TokensValidAfterMillis = f.Now().UnixNano() / int64(time.Millisecond)
+	return nil
+}
+
+func (f *FakeClient) sign(unsigned string) []byte {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
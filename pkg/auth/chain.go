@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"errors"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	errs "github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	PrincipalContextVal = "AUTH_PRINCIPAL"
+
+	StrategyJWT       = "jwt"
+	StrategyOIDC      = "oidc"
+	StrategyAPIKey    = "apikey"
+	StrategySharedKey = "sharedkey"
+	StrategyScheduler = "scheduler"
+	StrategyIP        = "ip"
+)
+
+var (
+	ErrNoCredentials = errors.New("no credentials")
+	ErrForbidden     = errors.New("forbidden")
+)
+
+// Principal is the caller identified by an Authenticator
+type Principal struct {
+	Strategy string                 `json:"strategy"` // strategies joined with + for AllOf
+	ID       string                 `json:"id"`       // uid, subject, api key id or client ip
+	Email    string                 `json:"email,omitempty"`
+	Roles    []string               `json:"roles,omitempty"` // roles for tokens, scopes for api keys
+	Claims   map[string]interface{} `json:"-"`
+}
+
+// Authenticator identifies the caller of a request
+// it returns ErrNoCredentials (401) or an error wrapping ErrForbidden (403) when the caller is known but not allowed
+type Authenticator func(c *gin.Context) (*Principal, error)
+
+// JWTAuthenticator accepts Firebase ID tokens or session cookies as configured by opts (see AuthJWT)
+// the token is also stored under FirebaseContextVal
+func JWTAuthenticator(client TokenVerifier, opts ...JWTOptions) Authenticator {
+	var o JWTOptions
+	if len(opts) > 0 {
+		o = opts[0]
+		o.check(client)
+	}
+
+	return func(c *gin.Context) (*Principal, error) {
+		idToken, strategy, err := o.verify(c, client)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Set(FirebaseContextVal, idToken)
+		p := tokenPrincipal(idToken)
+		p.Strategy = strategy
+		return p, nil
+	}
+}
+
+// OIDCAuthenticator accepts tokens verified by v, the claims are also stored under OIDCContextVal
+func OIDCAuthenticator(v *OIDCVerifier) Authenticator {
+	return func(c *gin.Context) (*Principal, error) {
+		token, ok := bearerToken(c)
+		if !ok {
+			return nil, ErrNoCredentials
+		}
+
+		claims, err := v.Verify(c, token)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Set(OIDCContextVal, claims)
+		return &Principal{Strategy: StrategyOIDC, ID: claims.Subject, Email: claims.Email, Claims: claims.Raw}, nil
+	}
+}
+
+// APIKeyAuthenticator accepts keys from store granting every one of scopes, the key is also stored under APIKeyContextVal
+func APIKeyAuthenticator(store *APIKeyStore, scopes ...string) Authenticator {
+	return func(c *gin.Context) (*Principal, error) {
+		plaintext := c.Request.Header.Get(apiKeyHeader)
+		if plaintext == "" {
+			return nil, ErrNoCredentials
+		}
+
+		key, err := store.Verify(c, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		for _, scope := range scopes {
+			if !key.HasScope(scope) {
+				return nil, errs.Wrap(ErrForbidden, "api key missing scope "+scope)
+			}
+		}
+
+		c.Set(APIKeyContextVal, key)
+		return &Principal{Strategy: StrategyAPIKey, ID: key.ID, Roles: key.Scopes}, nil
+	}
+}
+
+// SharedKeyAuthenticator accepts the single secret used by AuthAPIKey
+func SharedKeyAuthenticator(secret string) Authenticator {
+	return func(c *gin.Context) (*Principal, error) {
+		if c.Request.Header.Get(apiKeyHeader) == "" {
+			return nil, ErrNoCredentials
+		}
+		if !sharedKeyMatches(secret, c.Request.Header.Get(apiKeyHeader)) {
+			return nil, errors.New("api key mismatch")
+		}
+		return &Principal{Strategy: StrategySharedKey}, nil
+	}
+}
+
+// SchedulerAuthenticator accepts requests passing any of strategies e.g. GoogleServiceAccount or AppEngineHeaders
+func SchedulerAuthenticator(strategies ...SchedulerStrategy) Authenticator {
+	return func(c *gin.Context) (*Principal, error) {
+		var err error
+		for _, s := range strategies {
+			if err = s(c); err == nil {
+				p := &Principal{Strategy: StrategyScheduler}
+				if claims, ok := c.Value(OIDCContextVal).(*OIDCClaims); ok && claims != nil {
+					p.ID, p.Email, p.Claims = claims.Subject, claims.Email, claims.Raw
+				}
+				return p, nil
+			}
+		}
+		if err == nil {
+			err = ErrNoCredentials
+		}
+		return nil, err
+	}
+}
+
+// IPAuthenticator accepts requests from addresses allowed by f
+func IPAuthenticator(f *IPFilter) Authenticator {
+	return func(c *gin.Context) (*Principal, error) {
+		ip := f.ClientIP(c.Request)
+		if !f.Allowed(ip) {
+			return nil, errs.Wrap(ErrForbidden, "ip not allowed "+ip.String())
+		}
+		return &Principal{Strategy: StrategyIP, ID: ip.String()}, nil
+	}
+}
+
+// Gin middleware allowing requests accepted by any of authenticators, tried in order
+// the first Principal is stored under PrincipalContextVal, rejections are 403 if any authenticator returned ErrForbidden
+func AnyOf(authenticators ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		var reasons []error
+		for _, a := range authenticators {
+			p, err := a(c)
+			if err == nil && p == nil {
+				err = ErrNoCredentials
+			}
+			if err == nil {
+				auditRequest(c, start, AuditEvent{Strategy: p.Strategy, Outcome: OutcomeSuccess, Principal: p.ID})
+				c.Set(PrincipalContextVal, p)
+				c.Next()
+				return
+			}
+			reasons = append(reasons, err)
+		}
+
+		abortAuth(c, start, reasons)
+	}
+}
+
+// Gin middleware allowing requests accepted by every one of authenticators e.g. a JWT from an internal IP
+// the Principal of the first authenticator is stored under PrincipalContextVal, panics without authenticators
+func AllOf(authenticators ...Authenticator) gin.HandlerFunc {
+	if len(authenticators) == 0 {
+		panic("auth.AllOf requires at least one authenticator")
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		var principal *Principal
+		var strategies []string
+		for _, a := range authenticators {
+			p, err := a(c)
+			if err == nil && p == nil {
+				err = ErrNoCredentials
+			}
+			if err != nil {
+				abortAuth(c, start, []error{err})
+				return
+			}
+			if principal == nil {
+				principal = p
+			}
+			strategies = append(strategies, p.Strategy)
+		}
+
+		principal.Strategy = strings.Join(strategies, "+")
+		auditRequest(c, start, AuditEvent{Strategy: principal.Strategy, Outcome: OutcomeSuccess, Principal: principal.ID})
+		c.Set(PrincipalContextVal, principal)
+		c.Next()
+	}
+}
+
+// GetPrincipal returns the Principal stored by AnyOf or AllOf
+func GetPrincipal(c *gin.Context) *Principal {
+	if v, ok := c.Get(PrincipalContextVal); ok {
+		if p, ok := v.(*Principal); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func abortAuth(c *gin.Context, start time.Time, reasons []error) {
+	status, outcome := http.StatusUnauthorized, OutcomeFailure
+	var messages []string
+	for _, err := range reasons {
+		if auditOutcome(err) == OutcomeDenied {
+			status, outcome = http.StatusForbidden, OutcomeDenied
+		}
+		messages = append(messages, err.Error())
+	}
+
+	log.Println("auth rejected:", reasons)
+	auditRequest(c, start, AuditEvent{Outcome: outcome, Reason: strings.Join(messages, "; ")})
+	c.AbortWithStatusJSON(status, gin.H{
+		"code":    status,
+		"message": http.StatusText(status),
+	})
+}
+
+func tokenPrincipal(token *fbauth.Token) *Principal {
+	p := &Principal{Strategy: StrategyJWT, ID: token.UID, Roles: ClaimRoles(token.Claims), Claims: token.Claims}
+	p.Email, _ = token.Claims["email"].(string)
+	return p
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.Request.Header.Get(authorizationHeader)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnyOfAllOf(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := authtest.NewFakeClient()
+	jwt, _ := client.Mint("user-1", map[string]interface{}{"roles": []string{"editor"}})
+	internal, _ := NewIPFilter(IPFilterOptions{Allow: PrivateRanges})
+	store := NewAPIKeyStore(&fakeDatastore{entities: map[string]APIKeyRecord{}})
+	readOnly, _, _ := store.Create(context.Background(), "reader", []string{"reports:read"}, time.Time{})
+
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		p := GetPrincipal(c)
+		c.String(http.StatusOK, p.Strategy+" "+p.ID)
+	}
+	r.GET("/any", AnyOf(JWTAuthenticator(client), APIKeyAuthenticator(store, "reports:write"), SharedKeyAuthenticator("secret")), handler)
+	r.GET("/all", AllOf(JWTAuthenticator(client), IPAuthenticator(internal)), handler)
+
+	tt := []struct {
+		name     string
+		path     string
+		remote   string
+		headers  map[string]string
+		expected int
+		body     string
+	}{
+		{"any jwt", "/any", "8.8.8.8:1", map[string]string{"Authorization": "Bearer " + jwt}, http.StatusOK, "jwt user-1"},
+		{"any shared key", "/any", "8.8.8.8:1", map[string]string{"X-API-Key": "secret"}, http.StatusOK, "sharedkey "},
+		{"any bad jwt", "/any", "8.8.8.8:1", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized, ""},
+		{"any api key missing scope", "/any", "8.8.8.8:1", map[string]string{"X-API-Key": readOnly}, http.StatusForbidden, ""},
+		{"any none", "/any", "8.8.8.8:1", nil, http.StatusUnauthorized, ""},
+		{"all", "/all", "10.0.0.1:1", map[string]string{"Authorization": "Bearer " + jwt}, http.StatusOK, "jwt+ip user-1"},
+		{"all external ip", "/all", "8.8.8.8:1", map[string]string{"Authorization": "Bearer " + jwt}, http.StatusForbidden, ""},
+		{"all missing jwt", "/all", "10.0.0.1:1", nil, http.StatusUnauthorized, ""},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+			continue
+		}
+		if tc.expected == http.StatusOK && w.Body.String() != tc.body {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.body, w.Body.String())
+		}
+		if tc.expected != http.StatusOK {
+			var body struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tc.expected || body.Message != http.StatusText(tc.expected) {
+				t.Errorf("test failed; input: %v, wanted unified error body, got: %v", tc.name, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestAllOfRequiresAuthenticators(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", "AllOf()", "panic", nil)
+		}
+	}()
+	AllOf()
+}
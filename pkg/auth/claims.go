@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	errs "github.com/pkg/errors"
+	"log"
+)
+
+// Firebase rejects custom claims larger than this once serialised
+const maxClaimsBytes = 1000
+
+var ErrClaimsTooLarge = errors.New("custom claims exceed 1000 bytes")
+
+// claims set by Firebase which can't be used as custom claims
+var reservedClaims = map[string]bool{
+	"acr": true, "amr": true, "at_hash": true, "aud": true, "auth_time": true, "azp": true, "cnf": true, "c_hash": true,
+	"exp": true, "firebase": true, "iat": true, "iss": true, "jti": true, "nbf": true, "nonce": true, "sub": true,
+}
+
+type ClaimOptions struct {
+	// revoke the user's refresh tokens so the change is enforced once their current ID token expires,
+	// combine with revocation checks (VerifyIDTokenAndCheckRevoked) for it to take effect immediately
+	RevokeRefreshTokens bool
+}
+
+// options of functions taking them as an optional last argument
+func firstClaimOptions(opts []ClaimOptions) ClaimOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return ClaimOptions{}
+}
+
+// ValidateClaims checks claims don't use reserved names and fit within the Firebase size limit
+func ValidateClaims(claims map[string]interface{}) error {
+	for k := range claims {
+		if reservedClaims[k] {
+			return fmt.Errorf("claim %s is reserved", k)
+		}
+	}
+
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return errs.Wrap(err, "failed to serialise claims")
+	}
+	if len(b) > maxClaimsBytes {
+		return errs.Wrap(ErrClaimsTooLarge, fmt.Sprintf("%d bytes", len(b)))
+	}
+	return nil
+}
+
+// MergeClaims adds claims to the user's existing custom claims, a nil value removes the claim
+// returns the claims now stored on the user
+func MergeClaims(ctx context.Context, client UserManager, uid string, claims map[string]interface{}, opts ClaimOptions) (map[string]interface{}, error) {
+	user, err := client.GetUser(ctx, uid)
+	if err != nil {
+		return nil, errs.Wrap(err, "error getting user")
+	}
+
+	merged := mergeClaims(user.CustomClaims, claims)
+	if err := ValidateClaims(merged); err != nil {
+		return nil, err
+	}
+
+	// nil clears every custom claim
+	var set map[string]interface{}
+	if len(merged) > 0 {
+		set = merged
+	}
+	if err := client.SetCustomUserClaims(ctx, uid, set); err != nil {
+		return nil, errs.Wrap(err, "error setting custom claims")
+	}
+
+	if opts.RevokeRefreshTokens {
+		if err := client.RevokeRefreshTokens(ctx, uid); err != nil {
+			return merged, errs.Wrap(err, "error revoking refresh tokens")
+		}
+		log.Println("revoked refresh tokens for", uid)
+	}
+
+	return merged, nil
+}
+
+// RemoveClaims removes keys from the user's custom claims, keeping the rest
+func RemoveClaims(ctx context.Context, client UserManager, uid string, opts ClaimOptions, keys ...string) (map[string]interface{}, error) {
+	remove := map[string]interface{}{}
+	for _, k := range keys {
+		remove[k] = nil
+	}
+	return MergeClaims(ctx, client, uid, remove, opts)
+}
+
+func mergeClaims(existing map[string]interface{}, claims map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
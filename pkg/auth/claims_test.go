@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeClaims(t *testing.T) {
+	existing := map[string]interface{}{"tenant": "acme", "plan": "pro", "admin": true}
+
+	merged := mergeClaims(existing, map[string]interface{}{"admin": nil, "roles": []string{"editor"}})
+	want := map[string]interface{}{"tenant": "acme", "plan": "pro", "roles": []string{"editor"}}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("merge failed; wanted: %v, got: %v", want, merged)
+	}
+
+	if _, ok := existing["roles"]; ok {
+		t.Errorf("merge modified the existing claims")
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	if err := ValidateClaims(map[string]interface{}{"tenant": "acme"}); err != nil {
+		t.Errorf("valid claims rejected: %v", err)
+	}
+
+	if err := ValidateClaims(map[string]interface{}{"sub": "someone"}); err == nil {
+		t.Errorf("reserved claim accepted")
+	}
+
+	big := map[string]interface{}{"blob": strings.Repeat("x", maxClaimsBytes)}
+	if err := ValidateClaims(big); !errors.Is(err, ErrClaimsTooLarge) {
+		t.Errorf("wanted ErrClaimsTooLarge, got: %v", err)
+	}
+}
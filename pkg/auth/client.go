@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"context"
+	fbauth "firebase.google.com/go/auth"
+)
+
+// TokenVerifier verifies Firebase ID tokens, satisfied by *fbauth.Client and authtest.FakeClient
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*fbauth.Token, error)
+}
+
+// UserManager reads and updates Firebase users, satisfied by *fbauth.Client and authtest.FakeClient
+type UserManager interface {
+	GetUser(ctx context.Context, uid string) (*fbauth.UserRecord, error)
+	SetCustomUserClaims(ctx context.Context, uid string, customClaims map[string]interface{}) error
+	RevokeRefreshTokens(ctx context.Context, uid string) error
+}
+
+// AuthClient is everything the package needs from Firebase
+type AuthClient interface {
+	TokenVerifier
+	UserManager
+}
+
+var _ AuthClient = (*fbauth.Client)(nil)
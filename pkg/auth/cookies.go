@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	StrategySession = "session"
+
+	// Firebase Hosting only forwards this cookie to Cloud Run and Cloud Functions
+	DefaultSessionCookie     = "__session"
+	defaultSessionCookieTTL  = 5 * 24 * time.Hour
+	maxSessionCookieTTL      = 14 * 24 * time.Hour
+	minSessionCookieTTL      = 5 * time.Minute
+	sessionLoginMaxSignInAge = 5 * time.Minute
+	// the client copies this cookie into the csrfToken field of the login body
+	DefaultCSRFCookie = "csrfToken"
+)
+
+// RevocationVerifier verifies ID tokens and checks they haven't been revoked, satisfied by *fbauth.Client and *Client
+type RevocationVerifier interface {
+	VerifyIDTokenAndCheckRevoked(ctx context.Context, idToken string) (*fbauth.Token, error)
+}
+
+// SessionCookieVerifier verifies Firebase session cookies, satisfied by *fbauth.Client and *Client
+type SessionCookieVerifier interface {
+	VerifySessionCookie(ctx context.Context, sessionCookie string) (*fbauth.Token, error)
+	VerifySessionCookieAndCheckRevoked(ctx context.Context, sessionCookie string) (*fbauth.Token, error)
+}
+
+// SessionCookieIssuer creates session cookies from ID tokens, satisfied by *fbauth.Client and *Client
+type SessionCookieIssuer interface {
+	TokenVerifier
+	SessionCookie(ctx context.Context, idToken string, expiresIn time.Duration) (string, error)
+}
+
+var (
+	_ RevocationVerifier    = (*fbauth.Client)(nil)
+	_ SessionCookieVerifier = (*fbauth.Client)(nil)
+	_ SessionCookieIssuer   = (*fbauth.Client)(nil)
+)
+
+type JWTOptions struct {
+	// reject revoked tokens and disabled users, costs a user lookup per request, the client must be a RevocationVerifier
+	CheckRevoked bool
+	// cookie holding an ID token, read when there's no Authorization header
+	TokenCookie string
+	// cookie holding a Firebase session cookie, read when there's no Authorization header or token cookie
+	// the client must be a SessionCookieVerifier
+	SessionCookie string
+}
+
+// finds and verifies the credentials of a request, returning the strategy used
+func (o JWTOptions) verify(c *gin.Context, client TokenVerifier) (*fbauth.Token, string, error) {
+	token := strings.Replace(c.Request.Header.Get(authorizationHeader), "Bearer ", "", 1)
+	if token == "" && o.TokenCookie != "" {
+		token, _ = c.Cookie(o.TokenCookie)
+	}
+
+	if token == "" && o.SessionCookie != "" {
+		if cookie, err := c.Cookie(o.SessionCookie); err == nil && cookie != "" {
+			verifier := client.(SessionCookieVerifier)
+			if o.CheckRevoked {
+				t, err := verifier.VerifySessionCookieAndCheckRevoked(c, cookie)
+				return t, StrategySession, err
+			}
+			t, err := verifier.VerifySessionCookie(c, cookie)
+			return t, StrategySession, err
+		}
+	}
+
+	if token == "" {
+		return nil, StrategyJWT, ErrNoCredentials
+	}
+	if o.CheckRevoked {
+		t, err := client.(RevocationVerifier).VerifyIDTokenAndCheckRevoked(c, token)
+		return t, StrategyJWT, err
+	}
+	t, err := client.VerifyIDToken(c, token) // usually hits a local cache
+	return t, StrategyJWT, err
+}
+
+// panics at startup rather than failing the first request when the client can't do what the options need
+func (o JWTOptions) check(client TokenVerifier) {
+	if _, ok := client.(RevocationVerifier); o.CheckRevoked && !ok {
+		panic("auth.JWTOptions.CheckRevoked requires a client with VerifyIDTokenAndCheckRevoked")
+	}
+	if _, ok := client.(SessionCookieVerifier); o.SessionCookie != "" && !ok {
+		panic("auth.JWTOptions.SessionCookie requires a client with VerifySessionCookie")
+	}
+}
+
+type SessionCookieOptions struct {
+	Name       string        // defaults to __session
+	ExpiresIn  time.Duration // between 5 minutes and 14 days, defaults to 5 days
+	Path       string        // defaults to /
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite // defaults to Lax
+	CSRFCookie string        // double submit cookie checked by SessionLogin, defaults to csrfToken
+}
+
+func (o SessionCookieOptions) withDefaults() SessionCookieOptions {
+	if o.Name == "" {
+		o.Name = DefaultSessionCookie
+	}
+	if o.ExpiresIn == 0 {
+		o.ExpiresIn = defaultSessionCookieTTL
+	}
+	if o.ExpiresIn < minSessionCookieTTL {
+		o.ExpiresIn = minSessionCookieTTL
+	}
+	if o.ExpiresIn > maxSessionCookieTTL {
+		o.ExpiresIn = maxSessionCookieTTL
+	}
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	// the cookie authenticates requests on its own, so don't send it with cross site posts
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	if o.CSRFCookie == "" {
+		o.CSRFCookie = DefaultCSRFCookie
+	}
+	return o
+}
+
+// SetSessionCookie exchanges idToken for a session cookie and sets it on the response
+func SetSessionCookie(c *gin.Context, client SessionCookieIssuer, idToken string, opts SessionCookieOptions) error {
+	opts = opts.withDefaults()
+	cookie, err := client.SessionCookie(c, idToken, opts.ExpiresIn)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     opts.Name,
+		Value:    cookie,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		MaxAge:   int(opts.ExpiresIn.Seconds()),
+		Secure:   opts.Secure,
+		HttpOnly: true,
+		SameSite: opts.SameSite,
+	})
+	return nil
+}
+
+// ClearSessionCookie removes the session cookie, revoke the user's refresh tokens to invalidate copies of it
+func ClearSessionCookie(c *gin.Context, opts SessionCookieOptions) {
+	opts = opts.withDefaults()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     opts.Name,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		MaxAge:   -1,
+		Secure:   opts.Secure,
+		HttpOnly: true,
+		SameSite: opts.SameSite,
+	})
+}
+
+// Gin handler creating a session cookie at login from the ID token in the JSON body {"idToken": "...", "csrfToken": "..."}
+// csrfToken must match the CSRFCookie set by the client (double submit) and only tokens from a sign in
+// within the last 5 minutes are accepted
+func SessionLogin(client SessionCookieIssuer, opts SessionCookieOptions) gin.HandlerFunc {
+	opts = opts.withDefaults()
+
+	return func(c *gin.Context) {
+		var body struct {
+			IDToken   string `json:"idToken" binding:"required"`
+			CSRFToken string `json:"csrfToken"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": http.StatusText(http.StatusBadRequest),
+			})
+			return
+		}
+
+		csrfCookie, _ := c.Cookie(opts.CSRFCookie)
+		if csrfCookie == "" || subtle.ConstantTimeCompare([]byte(csrfCookie), []byte(body.CSRFToken)) != 1 {
+			log.Println("session login rejected: csrf token mismatch")
+			auditRequest(c, time.Time{}, AuditEvent{Strategy: StrategySession, Outcome: OutcomeDenied, Reason: "csrf token mismatch"})
+			abortForbidden(c)
+			return
+		}
+
+		token, err := client.VerifyIDToken(c, body.IDToken)
+		if err != nil || time.Since(time.Unix(token.AuthTime, 0)) > sessionLoginMaxSignInAge {
+			log.Println("session login rejected:", err)
+			auditRequest(c, time.Time{}, AuditEvent{Strategy: StrategySession, Outcome: OutcomeFailure, Reason: "invalid or stale id token"})
+			abortUnauthorized(c)
+			return
+		}
+
+		if err := SetSessionCookie(c, client, body.IDToken, opts); err != nil {
+			log.Println("failed to create session cookie:", err)
+			abortUnauthorized(c)
+			return
+		}
+
+		auditRequest(c, time.Time{}, AuditEvent{Strategy: StrategySession, Outcome: OutcomeSuccess, Principal: token.UID, Reason: "session created"})
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
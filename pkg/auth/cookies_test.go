@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	_ RevocationVerifier    = (*authtest.FakeClient)(nil)
+	_ SessionCookieVerifier = (*authtest.FakeClient)(nil)
+	_ SessionCookieIssuer   = (*authtest.FakeClient)(nil)
+)
+
+func TestAuthJWTOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", nil)
+	client.CreateUser("user-2", nil)
+	now := time.Now()
+	client.Now = func() time.Time { return now }
+
+	stale, _ := client.Mint("user-1", nil)
+	now = now.Add(time.Minute)
+	client.RevokeRefreshTokens(context.Background(), "user-1")
+	now = now.Add(time.Minute)
+	fresh, _ := client.Mint("user-2", nil)
+
+	opts := JWTOptions{CheckRevoked: true, TokenCookie: "token"}
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		token, _ := contextToken(c)
+		c.String(http.StatusOK, token.UID)
+	}
+	r.GET("/default", AuthJWT(client), handler)
+	r.GET("/revoked", AuthJWT(client, opts), handler)
+
+	tt := []struct {
+		name     string
+		path     string
+		header   string
+		cookie   string
+		expected int
+	}{
+		{"revoked token without check", "/default", stale, "", http.StatusOK},
+		{"revoked token", "/revoked", stale, "", http.StatusUnauthorized},
+		{"valid token", "/revoked", fresh, "", http.StatusOK},
+		{"token cookie", "/revoked", "", fresh, http.StatusOK},
+		{"revoked token cookie", "/revoked", "", stale, http.StatusUnauthorized},
+		{"cookie ignored by default", "/default", "", fresh, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.header)
+		}
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", nil)
+	now := time.Now().Add(-10 * time.Minute)
+	client.Now = func() time.Time { return now }
+	staleToken, _ := client.Mint("user-1", nil)
+	now = time.Now()
+	idToken, _ := client.Mint("user-1", nil)
+
+	cookieOpts := SessionCookieOptions{Secure: true}
+	r := gin.New()
+	r.POST("/login", SessionLogin(client, cookieOpts))
+	r.POST("/logout", func(c *gin.Context) {
+		ClearSessionCookie(c, cookieOpts)
+	})
+	r.GET("/", AuthJWT(client, JWTOptions{SessionCookie: DefaultSessionCookie, CheckRevoked: true}), func(c *gin.Context) {
+		token, _ := contextToken(c)
+		c.String(http.StatusOK, token.UID)
+	})
+
+	loginWithCSRF := func(token string, cookie string, field string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"idToken": "`+token+`", "csrfToken": "`+field+`"}`))
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: DefaultCSRFCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	login := func(token string) *httptest.ResponseRecorder {
+		return loginWithCSRF(token, "csrf-1", "csrf-1")
+	}
+
+	w := login(idToken)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != DefaultSessionCookie || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("test failed; wanted session cookie, got: %v %v", w.Code, cookies)
+	}
+
+	csrf := []struct {
+		name   string
+		cookie string
+		field  string
+	}{
+		{"no csrf cookie", "", "csrf-1"},
+		{"no csrf field", "csrf-1", ""},
+		{"csrf mismatch", "csrf-1", "csrf-2"},
+		{"both empty", "", ""},
+	}
+	for _, tc := range csrf {
+		if w := loginWithCSRF(idToken, tc.cookie, tc.field); w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, http.StatusForbidden, w.Code)
+		}
+	}
+
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: DefaultSessionCookie, Value: cookies[0].Value})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Errorf("test failed; input: session cookie, wanted: %v, got: %v", http.StatusOK, code)
+	}
+
+	// an ID token isn't a session cookie
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultSessionCookie, Value: idToken})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("test failed; input: id token as cookie, wanted: %v, got: %v", http.StatusUnauthorized, w.Code)
+	}
+
+	// sign in must be recent
+	if w := login(staleToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("test failed; input: stale login, wanted: %v, got: %v", http.StatusUnauthorized, w.Code)
+	}
+
+	now = now.Add(time.Minute)
+	client.RevokeRefreshTokens(context.Background(), "user-1")
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("test failed; input: revoked session, wanted: %v, got: %v", http.StatusUnauthorized, code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/logout", nil))
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge != -1 {
+		t.Errorf("test failed; wanted cleared cookie, got: %v", cookies)
+	}
+}
+
+type verifierOnly struct{}
+
+func (verifierOnly) VerifyIDToken(ctx context.Context, idToken string) (*fbauth.Token, error) {
+	return nil, ErrInvalidToken
+}
+
+func TestAuthJWTOptionsCheck(t *testing.T) {
+	tt := []struct {
+		name string
+		opts JWTOptions
+	}{
+		{"check revoked", JWTOptions{CheckRevoked: true}},
+		{"session cookie", JWTOptions{SessionCookie: DefaultSessionCookie}},
+	}
+
+	for _, tc := range tt {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, "panic", nil)
+				}
+			}()
+			AuthJWT(verifierOnly{}, tc.opts)
+		}()
+	}
+}
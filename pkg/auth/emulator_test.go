@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stand-in for the user management endpoints of the auth emulator
+type fakeEmulator struct {
+	mu    sync.Mutex
+	users map[string]map[string]interface{}
+}
+
+func (e *fakeEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer owner" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch r.URL.Path {
+	case "/identitytoolkit.googleapis.com/v1/projects/demo-test/accounts:lookup":
+		uid := body["localId"].([]interface{})[0].(string)
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": []interface{}{e.users[uid]}})
+	case "/identitytoolkit.googleapis.com/v1/projects/demo-test/accounts:update":
+		uid := body["localId"].(string)
+		for k, v := range body {
+			e.users[uid][k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"localId": uid})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func emulatorToken(t *testing.T, project string, uid string, authTime time.Time) string {
+	token, err := unsignedToken(map[string]interface{}{
+		"iss":       "https://securetoken.google.com/" + project,
+		"aud":       project,
+		"sub":       uid,
+		"auth_time": authTime.Unix(),
+		"iat":       authTime.Unix(),
+		"exp":       authTime.Add(time.Hour).Unix(),
+		"admin":     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestEmulator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	emulator := &fakeEmulator{users: map[string]map[string]interface{}{
+		"user-1": {"localId": "user-1", "email": "user@example.com"},
+	}}
+	server := httptest.NewServer(emulator)
+	defer server.Close()
+
+	opts := AuthOptions{ProjectID: "demo-test", EmulatorHost: strings.TrimPrefix(server.URL, "http://")}
+	if _, err := InitAuth(opts); err != ErrEmulatorRequiresClient {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "InitAuth", ErrEmulatorRequiresClient, err)
+	}
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := ElevateToAdmin(ctx, client, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAdmin(ctx, client, "user-1"); err != nil {
+		t.Errorf("test failed; wanted admin claim set on the emulator, got: %v", err)
+	}
+
+	issued := time.Now().Add(-time.Minute)
+	token := emulatorToken(t, "demo-test", "user-1", issued)
+
+	r := gin.New()
+	r.GET("/", AuthJWT(client), func(c *gin.Context) {
+		token, _ := contextToken(c)
+		c.String(http.StatusOK, token.UID)
+	})
+
+	tt := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"emulator token", token, http.StatusOK},
+		{"other project", emulatorToken(t, "other", "user-1", issued), http.StatusUnauthorized},
+		{"expired", emulatorToken(t, "demo-test", "user-1", time.Now().Add(-2*time.Hour)), http.StatusUnauthorized},
+	}
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+
+	if _, err := client.VerifyIDTokenAndCheckRevoked(ctx, token); err != nil {
+		t.Errorf("test failed; wanted token valid before revocation, got: %v", err)
+	}
+	if err := client.RevokeRefreshTokens(ctx, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.VerifyIDTokenAndCheckRevoked(ctx, token); err != ErrTokenRevoked {
+		t.Errorf("test failed; wanted: %v, got: %v", ErrTokenRevoked, err)
+	}
+}
@@ -21,30 +21,97 @@ const (
 
 var (
 	firebaseConfigFile = os.Getenv("FIREBASE_CONFIG_FILE")
+
+	ErrEmulatorRequiresClient = errors.New("InitAuth can't verify auth emulator tokens, use NewClient with FIREBASE_AUTH_EMULATOR_HOST")
 )
 
+type AuthOptions struct {
+	CredentialsFile string // service account key file, defaults to FIREBASE_CONFIG_FILE
+	CredentialsJSON []byte // service account key, used instead of a file e.g. from Secret Manager
+	ProjectID       string // required with ADC outside GCP and with the emulator, defaults to GOOGLE_CLOUD_PROJECT
+	EmulatorHost    string // host:port of the Auth emulator, defaults to FIREBASE_AUTH_EMULATOR_HOST
+}
+
+// Client is the Firebase auth client returned by NewClient
+// with the emulator tokens are verified without signatures as the emulator doesn't sign them
+type Client struct {
+	*fbauth.Client
+	projectID string
+	emulator  bool
+}
+
 // load firebase configuration and create the auth client
-// expecting env variable FIREBASE_CONFIG_FILE
-func InitAuth() (*fbauth.Client, error) {
-	if firebaseConfigFile == "" {
-		return nil, errors.New("FIREBASE_CONFIG_FILE required")
+// fails with the emulator as this client can't verify its unsigned tokens, use NewClient instead
+func InitAuth(opts ...AuthOptions) (*fbauth.Client, error) {
+	emulatorHost := os.Getenv(emulatorHostEnv)
+	if len(opts) > 0 && opts[0].EmulatorHost != "" {
+		emulatorHost = opts[0].EmulatorHost
+	}
+	if emulatorHost != "" {
+		return nil, ErrEmulatorRequiresClient
+	}
+
+	client, err := NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return client.Client, nil
+}
+
+// NewClient loads firebase configuration and creates the auth client wrapped to support the emulator
+// credentials are taken from opts, FIREBASE_CONFIG_FILE, then application default credentials
+// FIREBASE_AUTH_EMULATOR_HOST sends every request to the local emulator instead
+func NewClient(opts ...AuthOptions) (*Client, error) {
+	var o AuthOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.CredentialsFile == "" && len(o.CredentialsJSON) == 0 {
+		o.CredentialsFile = firebaseConfigFile
+	}
+	if o.ProjectID == "" {
+		o.ProjectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if o.EmulatorHost == "" {
+		o.EmulatorHost = os.Getenv(emulatorHostEnv)
 	}
 
-	opt := option.WithCredentialsFile(firebaseConfigFile)
-	app, err := firebase.NewApp(context.Background(), nil, opt)
+	var clientOpts []option.ClientOption
+	switch {
+	case o.EmulatorHost != "":
+		if o.ProjectID == "" {
+			return nil, errors.New("project id required with the auth emulator")
+		}
+		log.Println("using firebase auth emulator at", o.EmulatorHost)
+		clientOpts = append(clientOpts, option.WithoutAuthentication(), option.WithHTTPClient(emulatorHTTPClient(o.EmulatorHost)))
+	case len(o.CredentialsJSON) > 0:
+		clientOpts = append(clientOpts, option.WithCredentialsJSON(o.CredentialsJSON))
+	case o.CredentialsFile != "":
+		clientOpts = append(clientOpts, option.WithCredentialsFile(o.CredentialsFile))
+	default:
+		log.Println("no firebase credentials configured, using application default credentials")
+	}
+
+	var config *firebase.Config
+	if o.ProjectID != "" {
+		config = &firebase.Config{ProjectID: o.ProjectID}
+	}
+
+	ctx := context.Background()
+	app, err := firebase.NewApp(ctx, config, clientOpts...)
 	if err != nil {
 		return nil, errs.Wrap(err, "error initializing app, creating fb app")
 	}
 
-	client, errAuth := app.Auth(context.Background())
-	if errAuth != nil {
+	client, err := app.Auth(ctx)
+	if err != nil {
 		return nil, errs.Wrap(err, "error initializing auth, creating fb client")
 	}
-	return client, nil
+	return &Client{Client: client, projectID: o.ProjectID, emulator: o.EmulatorHost != ""}, nil
 }
 
 // use id_token provided in Authorization: Bearer [ID_TOKEN]
-func VerifyToken(c *gin.Context, client *fbauth.Client) (int, string, error) {
+func VerifyToken(c *gin.Context, client TokenVerifier) (int, string, error) {
 	authHeader := c.Request.Header.Get(authorizationHeader)
 	token := strings.Replace(authHeader, "Bearer ", "", 1)
 
@@ -53,11 +120,9 @@ func VerifyToken(c *gin.Context, client *fbauth.Client) (int, string, error) {
 		return http.StatusUnauthorized, "not authenticated", errs.Wrap(err, "failed to verify token")
 	}
 
-	claims := idtoken.Claims
-	if admin, ok := claims["admin"]; ok {
-		if admin.(bool) {
-			return 0, "", nil
-		}
+	// same check as VerifyAdmin and RequireAdmin, the admin claim or the admin role
+	if contains(ClaimRoles(idtoken.Claims), AdminRole) {
+		return 0, "", nil
 	}
 
 	log.Println("not admin")
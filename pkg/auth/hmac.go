@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/cache"
+	errs "github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HMACContextVal = "HMAC_KEY_ID"
+	StrategyHMAC   = "hmac"
+
+	hmacKeyHeader       = "X-Signature-Key"
+	hmacTimestampHeader = "X-Signature-Timestamp"
+	hmacNonceHeader     = "X-Signature-Nonce"
+	hmacSignatureHeader = "X-Signature"
+
+	defaultHMACMaxSkew   = 5 * time.Minute
+	defaultHMACMaxBody   = 10 << 20
+	hmacNonceBytes       = 16
+	hmacNonceKeyPrefix   = "hmac-nonce:"
+	minHMACKeyBytes      = 32
+	maxHMACNonceLength   = 64
+	hmacSignatureVersion = "v1"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrSignatureExpired = errors.New("request timestamp outside the allowed clock skew")
+	ErrReplayedNonce    = errors.New("request nonce already used")
+	ErrNonceStore       = errors.New("nonce store unavailable")
+	ErrUnsignableBody   = errors.New("request body can't be signed without GetBody")
+)
+
+// NonceStore remembers nonces for ttl, Claim returns false if nonce was already claimed
+type NonceStore interface {
+	Claim(nonce string, ttl time.Duration) (bool, error)
+}
+
+type cacheNonces struct{}
+
+// CacheNonces stores nonces in redis with pkg/cache, shared by every instance of the service
+func CacheNonces() NonceStore {
+	return cacheNonces{}
+}
+
+func (cacheNonces) Claim(nonce string, ttl time.Duration) (bool, error) {
+	if !cache.Available() {
+		return false, ErrNonceStore
+	}
+	return cache.SetNX(hmacNonceKeyPrefix+nonce, []byte{1}, ttl)
+}
+
+// HMACTransport signs outgoing requests with a shared key, see HMACVerifier
+// requests with a body must set GetBody, which http.NewRequest does for bytes and strings readers
+type HMACTransport struct {
+	KeyID string
+	Key   []byte
+	Base  http.RoundTripper // defaults to http.DefaultTransport
+
+	now func() time.Time
+}
+
+// NewHMACClient returns a client signing every request with key
+func NewHMACClient(keyID string, key []byte) *http.Client {
+	return &http.Client{Transport: &HMACTransport{KeyID: keyID, Key: key}, Timeout: defaultRESTTimeout}
+}
+
+func (t *HMACTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read request body")
+	}
+
+	nonce, err := randomString(hmacNonceBytes)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	// RoundTrippers mustn't modify the request they were given
+	// the clone keeps the original body for the base transport to send and close
+	r := req.Clone(req.Context())
+	r.Header.Set(hmacKeyHeader, t.KeyID)
+	r.Header.Set(hmacTimestampHeader, timestamp)
+	r.Header.Set(hmacNonceHeader, nonce)
+	r.Header.Set(hmacSignatureHeader, signRequest(t.Key, r.Method, requestHost(r), requestPath(r), timestamp, nonce, body))
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// reads a copy of the body from GetBody, the original isn't read or closed
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, ErrUnsignableBody
+	}
+
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// HMACVerifier checks requests signed by HMACTransport
+// the signature covers the method, host, path and query, timestamp, nonce and a sha256 of the body
+// proxies in front of the service must preserve the Host header
+type HMACVerifier struct {
+	MaxSkew      time.Duration // accepted difference between the request timestamp and now, defaults to 5 minutes
+	MaxBodyBytes int64         // larger bodies are rejected, defaults to 10MB
+	Nonces       NonceStore    // defaults to CacheNonces, requests are rejected while it's unavailable
+
+	keys map[string][]byte
+	now  func() time.Time
+}
+
+// NewHMACVerifier accepts requests signed with any of keys, keyed by key id so keys can be rotated
+func NewHMACVerifier(keys map[string][]byte) (*HMACVerifier, error) {
+	for id, key := range keys {
+		if len(key) < minHMACKeyBytes {
+			return nil, errors.New("hmac key " + id + " must be at least 32 bytes")
+		}
+	}
+
+	return &HMACVerifier{
+		MaxSkew:      defaultHMACMaxSkew,
+		MaxBodyBytes: defaultHMACMaxBody,
+		Nonces:       CacheNonces(),
+		keys:         keys,
+		now:          time.Now,
+	}, nil
+}
+
+// Verify checks the signature of r and claims its nonce, returning the key id
+// the body is read and replaced so handlers can still bind it
+func (v *HMACVerifier) Verify(r *http.Request) (string, error) {
+	keyID := r.Header.Get(hmacKeyHeader)
+	signature := r.Header.Get(hmacSignatureHeader)
+	if keyID == "" || signature == "" {
+		return "", ErrNoCredentials
+	}
+
+	key, ok := v.keys[keyID]
+	if !ok {
+		return "", errs.Wrap(ErrInvalidSignature, "unknown key "+keyID)
+	}
+
+	nonce := r.Header.Get(hmacNonceHeader)
+	if nonce == "" || len(nonce) > maxHMACNonceLength {
+		return "", errs.Wrap(ErrInvalidSignature, "missing or invalid nonce")
+	}
+
+	timestamp := r.Header.Get(hmacTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", errs.Wrap(ErrInvalidSignature, "invalid timestamp")
+	}
+	skew := v.now().Sub(time.Unix(unix, 0))
+	if skew > v.MaxSkew || skew < -v.MaxSkew {
+		return "", ErrSignatureExpired
+	}
+
+	body, err := v.readBody(r)
+	if err != nil {
+		return "", err
+	}
+
+	expected := signRequest(key, r.Method, requestHost(r), requestPath(r), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", ErrInvalidSignature
+	}
+
+	// only claimed once the signature is valid so unsigned requests can't burn nonces
+	// kept for both sides of the skew window, after that the timestamp check rejects the request
+	fresh, err := v.Nonces.Claim(keyID+":"+nonce, 2*v.MaxSkew)
+	if err != nil {
+		return "", errs.Wrap(ErrNonceStore, err.Error())
+	}
+	if !fresh {
+		return "", ErrReplayedNonce
+	}
+	return keyID, nil
+}
+
+func (v *HMACVerifier) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, v.MaxBodyBytes+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read request body")
+	}
+	if int64(len(body)) > v.MaxBodyBytes {
+		return nil, errs.Wrap(ErrInvalidSignature, "body too large")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// HMACAuthenticator accepts requests verified by v, the key id is also stored under HMACContextVal
+func HMACAuthenticator(v *HMACVerifier) Authenticator {
+	return func(c *gin.Context) (*Principal, error) {
+		keyID, err := v.Verify(c.Request)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Set(HMACContextVal, keyID)
+		return &Principal{Strategy: StrategyHMAC, ID: keyID}, nil
+	}
+}
+
+// Gin middleware for requests signed by HMACTransport, the key id is stored under HMACContextVal
+// responds 503 while the nonce store is unavailable rather than accepting possibly replayed requests
+func AuthHMAC(v *HMACVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		keyID, err := v.Verify(c.Request)
+		if err != nil {
+			log.Println("hmac signature rejected:", err)
+			auditRequest(c, start, AuditEvent{Strategy: StrategyHMAC, Outcome: OutcomeFailure, Principal: c.Request.Header.Get(hmacKeyHeader), Reason: err.Error()})
+			if errs.Cause(err) == ErrNonceStore {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+					"code":    http.StatusServiceUnavailable,
+					"message": http.StatusText(http.StatusServiceUnavailable),
+				})
+				return
+			}
+			abortUnauthorized(c)
+			return
+		}
+
+		auditRequest(c, start, AuditEvent{Strategy: StrategyHMAC, Outcome: OutcomeSuccess, Principal: keyID})
+		c.Set(HMACContextVal, keyID)
+		c.Next()
+	}
+}
+
+// the signed host, the Host header when set otherwise the url host
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return strings.ToLower(r.Host)
+	}
+	return strings.ToLower(r.URL.Host)
+}
+
+// the signed path including the query, as sent on the wire
+func requestPath(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	return path
+}
+
+func signRequest(key []byte, method string, host string, path string, timestamp string, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		hmacSignatureVersion,
+		strings.ToUpper(method),
+		host,
+		path,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(canonical))
+	return hmacSignatureVersion + "=" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
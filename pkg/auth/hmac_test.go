@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeNonces struct {
+	mu     sync.Mutex
+	seen   map[string]bool
+	failed bool
+}
+
+func (f *fakeNonces) Claim(nonce string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failed {
+		return false, errors.New("redis down")
+	}
+	if f.seen[nonce] {
+		return false, nil
+	}
+	f.seen[nonce] = true
+	return true, nil
+}
+
+// records the last signed request so it can be replayed or tampered with
+type recordingTransport struct {
+	last *http.Request
+	body []byte
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.last = req
+	t.body = nil
+	if req.Body != nil {
+		t.body, _ = ioutil.ReadAll(req.Body)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestAuthHMAC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key := []byte(strings.Repeat("k", 32))
+	other := []byte(strings.Repeat("o", 32))
+
+	now := time.Now()
+	nonces := &fakeNonces{seen: map[string]bool{}}
+	v, err := NewHMACVerifier(map[string][]byte{"svc-a": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Nonces = nonces
+	v.now = func() time.Time { return now }
+
+	r := gin.New()
+	r.POST("/jobs", AuthHMAC(v), func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, c.GetString(HMACContextVal)+":"+string(body))
+	})
+
+	// signs a request with the transport and returns what it would have sent
+	sign := func(keyID string, k []byte, path string, body string, at time.Time) (*http.Request, []byte) {
+		rec := &recordingTransport{}
+		tr := &HMACTransport{KeyID: keyID, Key: k, Base: rec, now: func() time.Time { return at }}
+		req, _ := http.NewRequest(http.MethodPost, "http://svc"+path, strings.NewReader(body))
+		if _, err := tr.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		return rec.last, rec.body
+	}
+
+	tt := []struct {
+		name     string
+		prepare  func() (*http.Request, []byte)
+		failed   bool
+		expected int
+	}{
+		{"valid", func() (*http.Request, []byte) {
+			return sign("svc-a", key, "/jobs?run=1", `{"id":1}`, now)
+		}, false, http.StatusOK},
+		{"unsigned", func() (*http.Request, []byte) {
+			req, _ := http.NewRequest(http.MethodPost, "http://svc/jobs", nil)
+			return req, nil
+		}, false, http.StatusUnauthorized},
+		{"wrong key", func() (*http.Request, []byte) {
+			return sign("svc-a", other, "/jobs", `{"id":1}`, now)
+		}, false, http.StatusUnauthorized},
+		{"unknown key id", func() (*http.Request, []byte) {
+			return sign("svc-b", key, "/jobs", `{"id":1}`, now)
+		}, false, http.StatusUnauthorized},
+		{"tampered body", func() (*http.Request, []byte) {
+			req, _ := sign("svc-a", key, "/jobs", `{"id":1}`, now)
+			return req, []byte(`{"id":2}`)
+		}, false, http.StatusUnauthorized},
+		{"wrong host", func() (*http.Request, []byte) {
+			req, body := sign("svc-a", key, "/jobs", `{"id":1}`, now)
+			req.Host = "other-svc"
+			return req, body
+		}, false, http.StatusUnauthorized},
+		{"tampered query", func() (*http.Request, []byte) {
+			req, body := sign("svc-a", key, "/jobs?run=1", `{"id":1}`, now)
+			req.URL.RawQuery = "run=2"
+			return req, body
+		}, false, http.StatusUnauthorized},
+		{"too old", func() (*http.Request, []byte) {
+			return sign("svc-a", key, "/jobs", `{"id":1}`, now.Add(-6*time.Minute))
+		}, false, http.StatusUnauthorized},
+		{"too far ahead", func() (*http.Request, []byte) {
+			return sign("svc-a", key, "/jobs", `{"id":1}`, now.Add(6*time.Minute))
+		}, false, http.StatusUnauthorized},
+		{"within skew", func() (*http.Request, []byte) {
+			return sign("svc-a", key, "/jobs", `{"id":1}`, now.Add(-4*time.Minute))
+		}, false, http.StatusOK},
+		{"nonce store down", func() (*http.Request, []byte) {
+			return sign("svc-a", key, "/jobs", `{"id":1}`, now)
+		}, true, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range tt {
+		nonces.failed = tc.failed
+		signed, body := tc.prepare()
+		w := serveSigned(r, signed, body)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+
+	nonces.failed = false
+	signed, body := sign("svc-a", key, "/jobs", `{"id":3}`, now)
+	if w := serveSigned(r, signed, body); w.Code != http.StatusOK || w.Body.String() != `svc-a:{"id":3}` {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "body passed to handler", `svc-a:{"id":3}`, w.Code, w.Body.String())
+	}
+	if w := serveSigned(r, signed, body); w.Code != http.StatusUnauthorized {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "replayed", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func serveSigned(r http.Handler, signed *http.Request, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(signed.Method, signed.URL.RequestURI(), bytes.NewReader(body))
+	req.Header = signed.Header.Clone()
+	req.Host = signed.Host
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestNewHMACVerifierRequiresLongKeys(t *testing.T) {
+	if _, err := NewHMACVerifier(map[string][]byte{"svc-a": []byte("short")}); err == nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "short key", "error", err)
+	}
+}
+
+// a reader which records whether it was read or closed
+type trackedBody struct {
+	read, closed bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return 0, io.EOF
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestHMACTransportRequiresGetBody(t *testing.T) {
+	rec := &recordingTransport{}
+	tr := &HMACTransport{KeyID: "svc-a", Key: []byte(strings.Repeat("k", 32)), Base: rec}
+
+	body := &trackedBody{}
+	req, _ := http.NewRequest(http.MethodPost, "http://svc/jobs", body)
+	if _, err := tr.RoundTrip(req); !errors.Is(err, ErrUnsignableBody) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "no GetBody", ErrUnsignableBody, err)
+	}
+	if body.read || body.closed || rec.last != nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: read %v, closed %v", "no GetBody", "body untouched", body.read, body.closed)
+	}
+
+	// the base transport is given the original body
+	req, _ = http.NewRequest(http.MethodPost, "http://svc/jobs", strings.NewReader(`{"id":1}`))
+	original := req.Body
+	if _, err := tr.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if rec.last.Body != original || string(rec.body) != `{"id":1}` {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "GetBody", `{"id":1}`, string(rec.body))
+	}
+}
@@ -0,0 +1,11 @@
+// The autherr pkg holds the errors shared by pkg/auth and pkg/auth/authtest, so errors.Is matches
+// the same values whether the real or the fake client returned them
+package autherr
+
+import "errors"
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrTokenRevoked = errors.New("id token has been revoked")
+	ErrUserDisabled = errors.New("user has been disabled")
+)
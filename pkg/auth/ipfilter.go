@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	errs "github.com/pkg/errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+var (
+	// loopback, RFC 1918 and IPv6 unique local addresses
+	PrivateRanges = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+	// sources of Google Cloud health checks and the external/internal HTTP(S) load balancer proxies
+	GCPHealthCheckRanges = []string{"35.191.0.0/16", "130.211.0.0/22"}
+	// sources of legacy network load balancer health checks
+	GCPNetworkLBRanges = []string{"35.191.0.0/16", "209.85.152.0/22", "209.85.204.0/22"}
+
+	privateNets = mustParseCIDRs(PrivateRanges)
+	// proxies trusted by AuthInternalOnly, Cloud Run connections come from 169.254.0.0/16
+	internalProxyNets = mustParseCIDRs(append([]string{"169.254.0.0/16"}, PrivateRanges...))
+)
+
+type IPFilterOptions struct {
+	Allow          []string        // CIDRs allowed, empty allows every address which isn't denied
+	Deny           []string        // CIDRs rejected, takes precedence over Allow
+	TrustedProxies []string        // CIDRs of proxies whose X-Forwarded-For entries are trusted e.g. GCPHealthCheckRanges behind a load balancer
+	Reject         gin.HandlerFunc // called for rejected requests, must abort, defaults to 403
+}
+
+// IPFilter allows or denies requests by client IP
+type IPFilter struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies []*net.IPNet
+	reject  gin.HandlerFunc
+}
+
+func NewIPFilter(opts IPFilterOptions) (*IPFilter, error) {
+	f := &IPFilter{reject: opts.Reject}
+	var err error
+	if f.allow, err = parseCIDRs(opts.Allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseCIDRs(opts.Deny); err != nil {
+		return nil, err
+	}
+	if f.proxies, err = parseCIDRs(opts.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if f.reject == nil {
+		f.reject = abortForbidden
+	}
+	return f, nil
+}
+
+// Allowed reports whether ip passes the deny and allow lists
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if ip == nil || inNets(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || inNets(f.allow, ip)
+}
+
+// ClientIP returns the address of the client, X-Forwarded-For is only used when the request came through a trusted proxy
+// entries are read right to left skipping trusted proxies so a client can't spoof its address by sending the header
+func (f *IPFilter) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !inNets(f.proxies, ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values(forwardedForHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !inNets(f.proxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// address of the connection, ignoring any forwarding headers
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Gin middleware rejecting requests whose client IP isn't allowed
+func (f *IPFilter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := f.ClientIP(c.Request)
+		if !f.Allowed(ip) {
+			log.Println("rejected IP:", ip)
+			auditRequest(c, time.Time{}, AuditEvent{Strategy: StrategyIP, Outcome: OutcomeDenied, Principal: ip.String(), Reason: "ip not allowed"})
+			f.reject(c)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		// single addresses are allowed
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, errs.Wrap(err, "invalid cidr")
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
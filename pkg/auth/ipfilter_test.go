@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilterAllowed(t *testing.T) {
+	f, err := NewIPFilter(IPFilterOptions{
+		Allow: append([]string{"2001:db8::/32", "203.0.113.7"}, GCPHealthCheckRanges...),
+		Deny:  []string{"35.191.1.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		input    string
+		expected bool
+	}{
+		{"35.191.0.1", true},
+		{"130.211.3.255", true},
+		{"130.211.4.0", false},
+		{"35.191.1.10", false},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"10.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		if output := f.Allowed(net.ParseIP(test.input)); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+
+	if _, err := NewIPFilter(IPFilterOptions{Allow: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("test failed; wanted error for invalid cidr")
+	}
+}
+
+func TestIPFilterClientIP(t *testing.T) {
+	f, _ := NewIPFilter(IPFilterOptions{TrustedProxies: append([]string{"10.0.0.0/8"}, GCPHealthCheckRanges...)})
+
+	tt := []struct {
+		name     string
+		remote   string
+		xff      string
+		expected string
+	}{
+		{"direct", "198.51.100.1:1234", "", "198.51.100.1"},
+		{"untrusted proxy ignored", "198.51.100.1:1234", "10.0.0.1", "198.51.100.1"},
+		{"trusted proxy", "35.191.0.5:1234", "198.51.100.2", "198.51.100.2"},
+		{"spoofed entry skipped", "35.191.0.5:1234", "10.0.0.1, 198.51.100.2", "198.51.100.2"},
+		{"proxy chain", "10.0.0.2:1234", "198.51.100.2, 35.191.0.5", "198.51.100.2"},
+		{"ipv6", "[2001:db8::1]:1234", "", "2001:db8::1"},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+
+		if output := f.ClientIP(req); output.String() != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, output)
+		}
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f, _ := NewIPFilter(IPFilterOptions{
+		Allow: PrivateRanges,
+		Reject: func(c *gin.Context) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": http.StatusText(http.StatusNotFound)})
+		},
+	})
+
+	r := gin.New()
+	r.GET("/", f.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tt := []struct {
+		remote   string
+		expected int
+	}{
+		{"10.1.2.3:80", http.StatusOK},
+		{"[::1]:80", http.StatusOK},
+		{"8.8.8.8:80", http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.remote, tc.expected, w.Code)
+		}
+	}
+}
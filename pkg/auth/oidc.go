@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	errs "github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OIDCContextVal = "OIDC_CLAIMS"
+
+	defaultClockSkew   = time.Minute
+	defaultKeysMaxAge  = time.Hour
+	minKeysRefresh     = time.Minute
+	googleIssuer       = "https://accounts.google.com"
+	googleIssuerNoHTTP = "accounts.google.com"
+	googleJWKSURL      = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+var (
+	ErrUnknownIssuer = errors.New("token issuer not configured")
+	ErrInvalidToken  = errors.New("invalid token")
+)
+
+// Issuer is a trusted token issuer
+type Issuer struct {
+	Issuer    string   // expected iss claim
+	JWKSURL   string   // discovered from Issuer/.well-known/openid-configuration when empty
+	Audiences []string // at least one must be in the aud claim
+}
+
+// GoogleIssuers trusts Google signed ID tokens e.g. service accounts used by Cloud Scheduler and Pub/Sub push
+func GoogleIssuers(audiences ...string) []Issuer {
+	return []Issuer{
+		{Issuer: googleIssuer, JWKSURL: googleJWKSURL, Audiences: audiences},
+		{Issuer: googleIssuerNoHTTP, JWKSURL: googleJWKSURL, Audiences: audiences},
+	}
+}
+
+// Auth0Issuer trusts tokens from an Auth0 tenant e.g. my-tenant.eu.auth0.com
+func Auth0Issuer(domain string, audiences ...string) Issuer {
+	return Issuer{
+		Issuer:    "https://" + domain + "/",
+		JWKSURL:   "https://" + domain + "/.well-known/jwks.json",
+		Audiences: audiences,
+	}
+}
+
+// OIDCClaims are the normalised claims of a verified token
+type OIDCClaims struct {
+	Issuer        string                 `json:"iss"`
+	Subject       string                 `json:"sub"`
+	Audience      []string               `json:"aud"`
+	Email         string                 `json:"email"`
+	EmailVerified bool                   `json:"email_verified"`
+	AuthorizedBy  string                 `json:"azp"`
+	IssuedAt      time.Time              `json:"iat"`
+	ExpiresAt     time.Time              `json:"exp"`
+	Raw           map[string]interface{} `json:"-"`
+}
+
+// OIDCVerifier verifies JWTs against the JWKS of each configured issuer
+// keys are cached until they expire and refetched when a token uses an unknown key id
+type OIDCVerifier struct {
+	ClockSkew  time.Duration
+	HTTPClient *http.Client
+
+	issuers map[string]*issuerKeys
+	now     func() time.Time
+}
+
+type issuerKeys struct {
+	Issuer
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	expires  time.Time
+	fetched  time.Time // last fetch attempt
+	fetchErr error     // error of the last fetch attempt
+}
+
+func NewOIDCVerifier(issuers ...Issuer) *OIDCVerifier {
+	v := &OIDCVerifier{
+		ClockSkew:  defaultClockSkew,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+		issuers:    map[string]*issuerKeys{},
+		now:        time.Now,
+	}
+	for _, i := range issuers {
+		v.issuers[i.Issuer] = &issuerKeys{Issuer: i}
+	}
+	return v
+}
+
+// Gin middleware verifying a bearer token from any configured issuer, the claims are stored under OIDCContextVal
+func AuthOIDC(v *OIDCVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.Request.Header.Get(authorizationHeader), "Bearer ")
+		start := time.Now()
+		claims, err := v.Verify(c, token)
+		if err != nil {
+			log.Println("oidc token rejected:", err)
+			auditRequest(c, start, AuditEvent{Strategy: StrategyOIDC, Outcome: OutcomeFailure, Reason: err.Error()})
+			abortUnauthorized(c)
+			return
+		}
+		auditRequest(c, start, AuditEvent{Strategy: StrategyOIDC, Outcome: OutcomeSuccess, Principal: claims.Email})
+
+		c.Set(OIDCContextVal, claims)
+		c.Next()
+	}
+}
+
+// Verify checks the signature, issuer, audience and expiry of token
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*OIDCClaims, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, errs.Wrap(ErrInvalidToken, "incorrect number of segments")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(segments[1], &raw); err != nil {
+		return nil, err
+	}
+
+	iss, _ := raw["iss"].(string)
+	issuer, ok := v.issuers[iss]
+	if !ok {
+		return nil, errs.Wrap(ErrUnknownIssuer, iss)
+	}
+
+	key, err := issuer.key(ctx, v.HTTPClient, header.KeyID, v.now())
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, errs.Wrap(ErrInvalidToken, "malformed signature")
+	}
+	if err := verifySignature(header.Algorithm, key, segments[0]+"."+segments[1], sig); err != nil {
+		return nil, err
+	}
+
+	claims := normaliseClaims(raw)
+	if err := v.validate(claims, issuer.Audiences); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *OIDCVerifier) validate(claims *OIDCClaims, audiences []string) error {
+	now := v.now()
+	if claims.ExpiresAt.IsZero() || now.After(claims.ExpiresAt.Add(v.ClockSkew)) {
+		return errs.Wrap(ErrInvalidToken, "token expired")
+	}
+	if now.Add(v.ClockSkew).Before(claims.IssuedAt) {
+		return errs.Wrap(ErrInvalidToken, "token issued in the future")
+	}
+	if nbf, ok := claims.Raw["nbf"].(float64); ok && now.Add(v.ClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errs.Wrap(ErrInvalidToken, "token not yet valid")
+	}
+
+	for _, want := range audiences {
+		if contains(claims.Audience, want) {
+			return nil
+		}
+	}
+	return errs.Wrap(ErrInvalidToken, fmt.Sprintf("audience %v not accepted", claims.Audience))
+}
+
+// key returns the public key for kid, refreshing the key set when it has expired or kid is unknown
+func (i *issuerKeys) key(ctx context.Context, client *http.Client, kid string, now time.Time) (crypto.PublicKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key, ok := i.keys[kid]
+	if ok && !now.After(i.expires) {
+		return key, nil
+	}
+
+	// fetches are attempted at most once a minute, failed or not, so unknown key ids and
+	// outages of the endpoint don't make every request wait on another fetch
+	if now.Sub(i.fetched) < minKeysRefresh {
+		if ok {
+			return key, nil
+		}
+		if i.fetchErr != nil {
+			return nil, i.fetchErr
+		}
+		return nil, errs.Wrap(ErrInvalidToken, "unknown key id "+kid)
+	}
+
+	i.fetched = now
+	keys, maxAge, err := fetchJWKS(ctx, client, i.Issuer)
+	i.fetchErr = err
+	if err != nil {
+		// keep using the cached keys if the endpoint is temporarily unavailable
+		if ok {
+			log.Println("failed to refresh jwks, using cached keys:", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	i.keys, i.expires = keys, now.Add(maxAge)
+	if key, ok = keys[kid]; !ok {
+		return nil, errs.Wrap(ErrInvalidToken, "unknown key id "+kid)
+	}
+	return key, nil
+}
+
+func fetchJWKS(ctx context.Context, client *http.Client, issuer Issuer) (map[string]crypto.PublicKey, time.Duration, error) {
+	url := issuer.JWKSURL
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if _, err := getJSON(ctx, client, strings.TrimSuffix(issuer.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, 0, errs.Wrap(err, "failed openid discovery")
+		}
+		url = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	header, err := getJSON(ctx, client, url, &set)
+	if err != nil {
+		return nil, 0, errs.Wrap(err, "failed to fetch jwks")
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	if age, ok := cacheMaxAge(header.Get("Cache-Control")); ok {
+		return keys, age, nil
+	}
+	return keys, defaultKeysMaxAge, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code not 200, code: %d, url: %s", resp.StatusCode, url)
+	}
+	return resp.Header, json.Unmarshal(body, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errs.Wrap(ErrInvalidToken, "key type doesn't match RS256")
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return errs.Wrap(ErrInvalidToken, "invalid signature")
+		}
+		return nil
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return errs.Wrap(ErrInvalidToken, "key type doesn't match ES256")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errs.Wrap(ErrInvalidToken, "invalid signature")
+		}
+		return nil
+	}
+	return errs.Wrap(ErrInvalidToken, "unsupported algorithm "+alg)
+}
+
+func normaliseClaims(raw map[string]interface{}) *OIDCClaims {
+	c := &OIDCClaims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	c.Email, _ = raw["email"].(string)
+	c.AuthorizedBy, _ = raw["azp"].(string)
+
+	switch v := raw["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+
+	switch v := raw["aud"].(type) {
+	case string:
+		c.Audience = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				c.Audience = append(c.Audience, s)
+			}
+		}
+	}
+
+	if exp, ok := raw["exp"].(float64); ok {
+		c.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if iat, ok := raw["iat"].(float64); ok {
+		c.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	return c
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errs.Wrap(ErrInvalidToken, "malformed segment")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errs.Wrap(ErrInvalidToken, "malformed segment")
+	}
+	return nil
+}
+
+func cacheMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.TrimSpace(d)
+		if strings.HasPrefix(d, "max-age=") {
+			secs, err := strconv.Atoi(strings.TrimPrefix(d, "max-age="))
+			if err == nil {
+				return time.Duration(secs) * time.Second, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwks server signing tokens with a single rsa key which can be rotated
+type testIssuer struct {
+	mu      sync.Mutex
+	kid     string
+	key     *rsa.PrivateKey
+	fetches int
+	down    bool
+	server  *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	ti := &testIssuer{}
+	ti.rotate(t, "key-1")
+	ti.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ti.mu.Lock()
+		defer ti.mu.Unlock()
+		ti.fetches++
+		if ti.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": ti.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(ti.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(ti.key.E)).Bytes()),
+		}}})
+	}))
+	return ti
+}
+
+func (ti *testIssuer) rotate(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti.mu.Lock()
+	ti.kid, ti.key = kid, key
+	ti.mu.Unlock()
+}
+
+func (ti *testIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": ti.kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ti.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testClaims(iss string, aud string, exp time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   iss,
+		"aud":   aud,
+		"sub":   "123",
+		"email": "scheduler@project.iam.gserviceaccount.com",
+		"iat":   time.Now().Add(-time.Minute).Unix(),
+		"exp":   exp.Unix(),
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.server.Close()
+
+	const iss = "https://issuer.example.com"
+	v := NewOIDCVerifier(Issuer{Issuer: iss, JWKSURL: ti.server.URL, Audiences: []string{"my-api"}})
+	v.ClockSkew = 30 * time.Second
+	hour := time.Now().Add(time.Hour)
+
+	tt := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", ti.sign(t, testClaims(iss, "my-api", hour)), true},
+		{"wrong audience", ti.sign(t, testClaims(iss, "other-api", hour)), false},
+		{"unknown issuer", ti.sign(t, testClaims("https://evil.example.com", "my-api", hour)), false},
+		{"expired within skew", ti.sign(t, testClaims(iss, "my-api", time.Now().Add(-10*time.Second))), true},
+		{"expired", ti.sign(t, testClaims(iss, "my-api", time.Now().Add(-time.Minute))), false},
+		{"malformed", "not.a-token", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), tc.token)
+			if (err == nil) != tc.valid {
+				t.Fatalf("test failed; input: %v, wanted valid: %v, got: %v", tc.name, tc.valid, err)
+			}
+			if tc.valid && claims.Email != "scheduler@project.iam.gserviceaccount.com" {
+				t.Errorf("test failed; input: %v, wanted email, got: %v", tc.name, claims.Email)
+			}
+		})
+	}
+
+	if ti.fetches != 1 {
+		t.Errorf("test failed; wanted keys fetched once, got: %v", ti.fetches)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.server.Close()
+
+	const iss = "https://issuer.example.com"
+	v := NewOIDCVerifier(Issuer{Issuer: iss, JWKSURL: ti.server.URL, Audiences: []string{"my-api"}})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	if _, err := v.Verify(context.Background(), ti.sign(t, testClaims(iss, "my-api", now.Add(time.Hour)))); err != nil {
+		t.Fatal(err)
+	}
+
+	ti.rotate(t, "key-2")
+	rotated := ti.sign(t, testClaims(iss, "my-api", now.Add(time.Hour)))
+
+	// unknown key ids aren't refetched more than once a minute
+	if _, err := v.Verify(context.Background(), rotated); err == nil {
+		t.Fatal("test failed; wanted unknown key error before the refresh interval")
+	}
+
+	now = now.Add(2 * minKeysRefresh)
+	if _, err := v.Verify(context.Background(), rotated); err != nil {
+		t.Errorf("test failed; wanted rotated key accepted, got: %v", err)
+	}
+}
+
+func TestAuthOIDC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ti := newTestIssuer(t)
+	defer ti.server.Close()
+
+	const iss = "https://issuer.example.com"
+	v := NewOIDCVerifier(Issuer{Issuer: iss, JWKSURL: ti.server.URL, Audiences: []string{"my-api"}})
+
+	r := gin.New()
+	r.GET("/", AuthOIDC(v), func(c *gin.Context) {
+		claims := c.MustGet(OIDCContextVal).(*OIDCClaims)
+		c.String(http.StatusOK, claims.Subject)
+	})
+
+	tt := []struct {
+		name     string
+		header   string
+		expected int
+	}{
+		{"valid", "Bearer " + ti.sign(t, testClaims(iss, "my-api", time.Now().Add(time.Hour))), http.StatusOK},
+		{"wrong audience", "Bearer " + ti.sign(t, testClaims(iss, "other", time.Now().Add(time.Hour))), http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", tc.header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+}
+
+func TestOIDCFetchFailureBackoff(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.server.Close()
+	ti.down = true
+
+	const iss = "https://issuer.example.com"
+	v := NewOIDCVerifier(Issuer{Issuer: iss, JWKSURL: ti.server.URL, Audiences: []string{"my-api"}})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	token := ti.sign(t, testClaims(iss, "my-api", now.Add(time.Hour)))
+
+	// failed fetches are limited like unknown key ids
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify(context.Background(), token); err == nil {
+			t.Fatal("test failed; wanted an error while the jwks endpoint is down")
+		}
+	}
+	if ti.fetches != 1 {
+		t.Errorf("test failed; wanted: %v fetch, got: %v", 1, ti.fetches)
+	}
+
+	ti.mu.Lock()
+	ti.down = false
+	ti.mu.Unlock()
+	now = now.Add(2 * minKeysRefresh)
+	if _, err := v.Verify(context.Background(), token); err != nil || ti.fetches != 2 {
+		t.Errorf("test failed; wanted token accepted after %v fetches, got: %v after %v", 2, err, ti.fetches)
+	}
+}
@@ -3,32 +3,47 @@ package auth
 import (
 	"context"
 	"errors"
-	fbauth "firebase.google.com/go/auth"
 	errs "github.com/pkg/errors"
 )
 
 // add 'admin' claim to users JWT so they can perform admin actions (they still need to be authenticated with JWT)
-func ElevateToAdmin(ctx context.Context, client *fbauth.Client, uid string) error {
-	claims := map[string]interface{}{"admin": true}
-	err := client.SetCustomUserClaims(ctx, uid, claims)
+// other custom claims are kept, opts can revoke the user's refresh tokens so the change applies sooner
+func ElevateToAdmin(ctx context.Context, client UserManager, uid string, opts ...ClaimOptions) error {
+	_, err := MergeClaims(ctx, client, uid, map[string]interface{}{"admin": true}, firstClaimOptions(opts))
+	auditChange(ctx, AuditEvent{Action: ActionElevateAdmin, Outcome: auditOutcome(err), Target: uid, Reason: auditReason(err)})
 	if err != nil {
 		return errs.Wrap(err, "error setting custom claims")
 	}
 	return nil
 }
 
-// remove admin claim from users JWT so they can no longer perform admin actions
-func RevokeAdmin(ctx context.Context, client *fbauth.Client, uid string) error {
-	// setting claims to nil will remove admin rights
-	err := client.SetCustomUserClaims(ctx, uid, nil)
+// remove admin claim and admin role from users JWT so they can no longer perform admin actions
+// other custom claims and roles are kept, revoke the user's refresh tokens with opts so the current session
+// can't keep refreshing an admin ID token
+func RevokeAdmin(ctx context.Context, client UserManager, uid string, opts ...ClaimOptions) error {
+	err := revokeAdmin(ctx, client, uid, firstClaimOptions(opts))
+	auditChange(ctx, AuditEvent{Action: ActionRevokeAdmin, Outcome: auditOutcome(err), Target: uid, Reason: auditReason(err)})
+	return err
+}
+
+func revokeAdmin(ctx context.Context, client UserManager, uid string, opts ClaimOptions) error {
+	user, err := client.GetUser(ctx, uid)
 	if err != nil {
+		return errs.Wrap(err, "error getting user")
+	}
+
+	claims := map[string]interface{}{
+		"admin":    nil,
+		RolesClaim: rolesClaim(withoutRole(storedRoles(user.CustomClaims), AdminRole)),
+	}
+	if _, err := MergeClaims(ctx, client, uid, claims, opts); err != nil {
 		return errs.Wrap(err, "error revoking custom claims")
 	}
 	return nil
 }
 
-// use user id to very if user is admin or not
-func VerifyAdmin(ctx context.Context, client *fbauth.Client, uid string) error {
+// use user id to very if user is admin or not, either the legacy admin claim or the admin role counts
+func VerifyAdmin(ctx context.Context, client UserManager, uid string) error {
 	// get the user
 	user, err := client.GetUser(ctx, uid)
 	if err != nil {
@@ -36,10 +51,8 @@ func VerifyAdmin(ctx context.Context, client *fbauth.Client, uid string) error {
 	}
 
 	// The claims can be accessed on the user record.
-	if admin, ok := user.CustomClaims["admin"]; ok {
-		if admin.(bool) {
-			return nil
-		}
+	if contains(ClaimRoles(user.CustomClaims), AdminRole) {
+		return nil
 	}
 	return errors.New("not admin")
 }
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	"testing"
+	"time"
+)
+
+var _ AuthClient = (*authtest.FakeClient)(nil)
+
+func TestElevateAndRevokeAdmin(t *testing.T) {
+	ctx := context.Background()
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", map[string]interface{}{"tenant": "acme"})
+
+	if err := VerifyAdmin(ctx, client, "user-1"); err == nil {
+		t.Fatalf("user shouldn't be admin yet")
+	}
+
+	if err := ElevateToAdmin(ctx, client, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAdmin(ctx, client, "user-1"); err != nil {
+		t.Fatalf("user should be admin: %v", err)
+	}
+
+	if err := RevokeAdmin(ctx, client, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAdmin(ctx, client, "user-1"); err == nil {
+		t.Fatalf("admin should be revoked")
+	}
+
+	u, _ := client.GetUser(ctx, "user-1")
+	if u.CustomClaims["tenant"] != "acme" {
+		t.Errorf("other claims were lost: %v", u.CustomClaims)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	ctx := context.Background()
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", map[string]interface{}{"tenant": "acme"})
+
+	AddUserRole(ctx, client, "user-1", "editor")
+	AddUserRole(ctx, client, "user-1", "viewer")
+	AddUserRole(ctx, client, "user-1", "editor")
+	RemoveUserRole(ctx, client, "user-1", "viewer")
+
+	u, _ := client.GetUser(ctx, "user-1")
+	roles := ClaimRoles(u.CustomClaims)
+	if len(roles) != 1 || roles[0] != "editor" || u.CustomClaims["tenant"] != "acme" {
+		t.Errorf("unexpected claims: %v", u.CustomClaims)
+	}
+
+	// token minted after the change carries the roles
+	token, _ := client.Mint("user-1", nil)
+	verified, err := client.VerifyIDToken(ctx, token)
+	if err != nil || !contains(ClaimRoles(verified.Claims), "editor") {
+		t.Errorf("token missing roles; got: %v, err: %v", verified, err)
+	}
+}
+
+func TestLegacyAdminRoles(t *testing.T) {
+	ctx := context.Background()
+
+	tt := []struct {
+		name   string
+		change func(client UserManager) error
+	}{
+		{"add role then revoke admin", func(client UserManager) error {
+			if err := AddUserRole(ctx, client, "user-1", "viewer"); err != nil {
+				return err
+			}
+			// the legacy claim mustn't be copied into the stored roles
+			if u, _ := client.GetUser(ctx, "user-1"); contains(storedRoles(u.CustomClaims), AdminRole) {
+				return errors.New("admin copied into roles claim")
+			}
+			return RevokeAdmin(ctx, client, "user-1")
+		}},
+		{"remove admin role", func(client UserManager) error {
+			return RemoveUserRole(ctx, client, "user-1", AdminRole)
+		}},
+	}
+
+	for _, tc := range tt {
+		client := authtest.NewFakeClient()
+		client.CreateUser("user-1", map[string]interface{}{"admin": true, RolesClaim: []string{"editor"}})
+
+		if err := tc.change(client); err != nil {
+			t.Fatalf("test failed; input: %v, wanted: %v, got: %v", tc.name, nil, err)
+		}
+
+		u, _ := client.GetUser(ctx, "user-1")
+		roles := ClaimRoles(u.CustomClaims)
+		if contains(roles, AdminRole) || !contains(roles, "editor") {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, "[editor]", u.CustomClaims)
+		}
+	}
+}
+
+func TestRevokeAdminRevokesRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", nil)
+	now := time.Now()
+	client.Now = func() time.Time { return now }
+
+	if err := ElevateToAdmin(ctx, client, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := client.Mint("user-1", nil)
+	now = now.Add(time.Second)
+
+	tt := []struct {
+		name    string
+		opts    []ClaimOptions
+		revoked bool
+	}{
+		{"default keeps sessions", nil, false},
+		{"revoke refresh tokens", []ClaimOptions{{RevokeRefreshTokens: true}}, true},
+	}
+	for _, tc := range tt {
+		if err := RevokeAdmin(ctx, client, "user-1", tc.opts...); err != nil {
+			t.Fatal(err)
+		}
+		_, err := client.VerifyIDTokenAndCheckRevoked(ctx, token)
+		if (err != nil) != tc.revoked {
+			t.Errorf("test failed; input: %v, wanted revoked: %v, got: %v", tc.name, tc.revoked, err)
+		}
+	}
+}
+
+func TestFakeClientSharesErrors(t *testing.T) {
+	ctx := context.Background()
+	client := authtest.NewFakeClient()
+	client.CreateUser("user-1", map[string]interface{}{"tenant": "acme"})
+	token, _ := client.Mint("user-1", nil)
+
+	if _, err := client.GetUser(ctx, "user-9"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "missing user", ErrUserNotFound, err)
+	}
+
+	client.SetDisabled("user-1", true)
+	if _, err := client.VerifyIDTokenAndCheckRevoked(ctx, token); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "disabled user", ErrUserDisabled, err)
+	}
+
+	// changes to a fetched user aren't stored
+	u, _ := client.GetUser(ctx, "user-1")
+	u.CustomClaims["tenant"] = "globex"
+	u.Disabled = false
+	if u, _ = client.GetUser(ctx, "user-1"); u.CustomClaims["tenant"] != "acme" || !u.Disabled {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "modified copy", "acme disabled", u.CustomClaims, u.Disabled)
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	errs "github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSecureTokenURL     = "https://securetoken.googleapis.com"
+	DefaultIdentityToolkitURL = "https://identitytoolkit.googleapis.com"
+
+	googAPIKeyHeader   = "X-Goog-Api-Key"
+	defaultRESTTimeout = 10 * time.Second
+)
+
+// error messages returned by the Firebase Auth REST API
+const (
+	ErrCodeTokenExpired        = "TOKEN_EXPIRED"
+	ErrCodeUserDisabled        = "USER_DISABLED"
+	ErrCodeUserNotFound        = "USER_NOT_FOUND"
+	ErrCodeInvalidRefreshToken = "INVALID_REFRESH_TOKEN"
+	ErrCodeProjectNotFound     = "PROJECT_NOT_FOUND"
+)
+
+// FirebaseError is an error response from the Firebase Auth REST API
+type FirebaseError struct {
+	StatusCode int    // http status
+	Code       string // e.g. TOKEN_EXPIRED
+	Detail     string // text after the code e.g. "Too many unsuccessful login attempts"
+}
+
+func (e *FirebaseError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("firebase auth %d: %s: %s", e.StatusCode, e.Code, e.Detail)
+	}
+	return fmt.Sprintf("firebase auth %d: %s", e.StatusCode, e.Code)
+}
+
+// IsFirebaseError reports whether err is a FirebaseError with code
+func IsFirebaseError(err error, code string) bool {
+	fe, ok := errs.Cause(err).(*FirebaseError)
+	return ok && fe.Code == code
+}
+
+// RESTClient calls the Firebase Auth REST API with a project's web API key
+// the urls can be pointed at a local stand-in or the emulator for testing
+type RESTClient struct {
+	APIKey             string
+	HTTPClient         *http.Client
+	SecureTokenURL     string
+	IdentityToolkitURL string
+}
+
+// FIREBASE_AUTH_EMULATOR_HOST points the client at the emulator
+func NewRESTClient(apiKey string) *RESTClient {
+	c := &RESTClient{
+		APIKey:             apiKey,
+		HTTPClient:         &http.Client{Timeout: defaultRESTTimeout},
+		SecureTokenURL:     DefaultSecureTokenURL,
+		IdentityToolkitURL: DefaultIdentityToolkitURL,
+	}
+	if url := emulatorURL(DefaultSecureTokenURL); url != "" {
+		c.SecureTokenURL, c.IdentityToolkitURL = url, emulatorURL(DefaultIdentityToolkitURL)
+	}
+	return c
+}
+
+// post sends body as JSON and decodes the response into out, the api key is sent as a header so it isn't logged with the url
+func (c *RESTClient) post(ctx context.Context, url string, body interface{}, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errs.Wrap(err, "failed to serialise request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(googAPIKeyHeader, c.APIKey)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return errs.Wrap(err, "firebase auth request failed")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parseFirebaseError(resp.StatusCode, respBody)
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// {"error": {"code": 400, "message": "INVALID_PASSWORD : optional detail"}}
+func parseFirebaseError(status int, body []byte) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &e); err != nil || e.Error.Message == "" {
+		return &FirebaseError{StatusCode: status, Code: http.StatusText(status), Detail: string(body)}
+	}
+
+	fe := &FirebaseError{StatusCode: status, Code: e.Error.Message}
+	if i := strings.Index(e.Error.Message, " : "); i > 0 {
+		fe.Code, fe.Detail = e.Error.Message[:i], e.Error.Message[i+3:]
+	}
+	return fe
+}
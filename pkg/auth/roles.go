@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	fbauth "firebase.google.com/go/auth"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	errs "github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RolesClaim = "roles"
+	AdminRole  = "admin"
+)
+
+// RolePermissions maps a role to the permissions it grants
+// permissions are resource:action strings, "orders:*" grants every orders action and "*" grants everything
+type RolePermissions map[string][]string
+
+var (
+	rolePermissions = RolePermissions{AdminRole: {"*"}}
+	rolesMu         sync.RWMutex
+)
+
+// SetRolePermissions replaces the role to permission mapping used by RequirePermission
+func SetRolePermissions(rp RolePermissions) {
+	rolesMu.Lock()
+	defer rolesMu.Unlock()
+	rolePermissions = rp
+}
+
+// LoadRolePermissions reads a JSON role to permission mapping e.g. {"editor": ["orders:read", "orders:write"]}
+func LoadRolePermissions(path string) (RolePermissions, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read role permissions")
+	}
+
+	var rp RolePermissions
+	if err := json.Unmarshal(b, &rp); err != nil {
+		return nil, errs.Wrap(err, "failed to parse role permissions")
+	}
+	return rp, nil
+}
+
+// Allows reports whether any of roles grants permission
+func (rp RolePermissions) Allows(roles []string, permission string) bool {
+	for _, r := range roles {
+		for _, p := range rp[r] {
+			if permissionMatches(p, permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func permissionMatches(granted string, permission string) bool {
+	if granted == "*" || granted == permission {
+		return true
+	}
+	if strings.HasSuffix(granted, ":*") {
+		return strings.HasPrefix(permission, strings.TrimSuffix(granted, "*"))
+	}
+	return false
+}
+
+// ClaimRoles returns the roles stored in custom claims, the legacy admin claim counts as the admin role
+func ClaimRoles(claims map[string]interface{}) []string {
+	roles := storedRoles(claims)
+	if admin, ok := claims[AdminRole].(bool); ok && admin && !contains(roles, AdminRole) {
+		roles = append(roles, AdminRole)
+	}
+	return roles
+}
+
+// roles in the roles claim only, what's written back when roles change so the legacy admin claim isn't copied into it
+func storedRoles(claims map[string]interface{}) []string {
+	var roles []string
+	switch v := claims[RolesClaim].(type) {
+	case []interface{}:
+		for _, r := range v {
+			if s, ok := r.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	case []string:
+		roles = append(roles, v...)
+	}
+	return roles
+}
+
+// value of the roles claim, nil removes it
+func rolesClaim(roles []string) interface{} {
+	if len(roles) == 0 {
+		return nil
+	}
+	return roles
+}
+
+func withoutRole(roles []string, role string) []string {
+	var out []string
+	for _, r := range roles {
+		if r != role {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// SetUserRoles replaces the roles claim of the user, keeping their other custom claims including the legacy admin claim
+// the user needs to refresh their ID token before the change is visible to the middleware
+func SetUserRoles(ctx context.Context, client UserManager, uid string, roles ...string) error {
+	return setUserRoles(ctx, client, uid, roles, false)
+}
+
+// ReplaceUserRoles leaves the user with exactly roles, also removing the legacy admin claim unless roles include admin
+func ReplaceUserRoles(ctx context.Context, client UserManager, uid string, roles ...string) error {
+	return setUserRoles(ctx, client, uid, roles, true)
+}
+
+func setUserRoles(ctx context.Context, client UserManager, uid string, roles []string, replaceLegacy bool) error {
+	claims := map[string]interface{}{RolesClaim: rolesClaim(roles)}
+	if replaceLegacy && !contains(roles, AdminRole) {
+		claims[AdminRole] = nil
+	}
+
+	_, err := MergeClaims(ctx, client, uid, claims, ClaimOptions{})
+	auditChange(ctx, AuditEvent{Action: ActionSetRoles, Outcome: auditOutcome(err), Target: uid, Reason: strings.Join(roles, ",")})
+	return err
+}
+
+// AddUserRole grants role to the user
+func AddUserRole(ctx context.Context, client UserManager, uid string, role string) error {
+	user, err := client.GetUser(ctx, uid)
+	if err != nil {
+		return errs.Wrap(err, "error getting user")
+	}
+
+	roles := storedRoles(user.CustomClaims)
+	if contains(roles, role) {
+		return nil
+	}
+	return SetUserRoles(ctx, client, uid, append(roles, role)...)
+}
+
+// RemoveUserRole revokes role from the user, removing the admin role also removes the legacy admin claim
+func RemoveUserRole(ctx context.Context, client UserManager, uid string, role string) error {
+	if role == AdminRole {
+		return RevokeAdmin(ctx, client, uid)
+	}
+
+	user, err := client.GetUser(ctx, uid)
+	if err != nil {
+		return errs.Wrap(err, "error getting user")
+	}
+	return SetUserRoles(ctx, client, uid, withoutRole(storedRoles(user.CustomClaims), role)...)
+}
+
+// Gin middleware allowing users with any of roles, must run after AuthJWT
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := contextToken(c)
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+
+		for _, r := range ClaimRoles(token.Claims) {
+			if contains(roles, r) {
+				c.Next()
+				return
+			}
+		}
+
+		log.Println("missing role", roles, "uid:", token.UID)
+		auditRequest(c, time.Time{}, AuditEvent{Action: ActionAuthorize, Outcome: OutcomeDenied, Principal: token.UID, Reason: fmt.Sprintf("missing role %v", roles)})
+		abortForbidden(c)
+	}
+}
+
+// Gin middleware allowing users whose roles grant permission, must run after AuthJWT
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := contextToken(c)
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+
+		rolesMu.RLock()
+		allowed := rolePermissions.Allows(ClaimRoles(token.Claims), permission)
+		rolesMu.RUnlock()
+
+		if !allowed {
+			log.Println("missing permission", permission, "uid:", token.UID)
+			auditRequest(c, time.Time{}, AuditEvent{Action: ActionAuthorize, Outcome: OutcomeDenied, Principal: token.UID, Reason: "missing permission " + permission})
+			abortForbidden(c)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// token stored by AuthJWT
+func contextToken(c *gin.Context) (*fbauth.Token, bool) {
+	v, ok := c.Get(FirebaseContextVal)
+	if !ok {
+		return nil, false
+	}
+	token, ok := v.(*fbauth.Token)
+	return token, ok && token != nil
+}
+
+func abortUnauthorized(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"code":    http.StatusUnauthorized,
+		"message": http.StatusText(http.StatusUnauthorized),
+	})
+}
+
+func abortForbidden(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"code":    http.StatusForbidden,
+		"message": http.StatusText(http.StatusForbidden),
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
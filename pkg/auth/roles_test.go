@@ -0,0 +1,95 @@
+package auth
+
+import (
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPermissionAllows(t *testing.T) {
+	rp := RolePermissions{
+		"admin":  {"*"},
+		"editor": {"orders:*", "products:read"},
+		"viewer": {"orders:read"},
+	}
+
+	var tests = []struct {
+		roles      []string
+		permission string
+		expected   bool
+	}{
+		{[]string{"admin"}, "anything:write", true},
+		{[]string{"editor"}, "orders:write", true},
+		{[]string{"editor"}, "products:read", true},
+		{[]string{"editor"}, "products:write", false},
+		{[]string{"viewer"}, "orders:write", false},
+		{[]string{"viewer", "editor"}, "orders:write", true},
+		{[]string{"unknown"}, "orders:read", false},
+		{nil, "orders:read", false},
+	}
+
+	for _, test := range tests {
+		if output := rp.Allows(test.roles, test.permission); output != test.expected {
+			t.Errorf("test failed; input: %v %v, wanted: %v, got: %v", test.roles, test.permission, test.expected, output)
+		}
+	}
+}
+
+func TestClaimRoles(t *testing.T) {
+	var tests = []struct {
+		input    map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"roles": []interface{}{"editor", "viewer"}}, []string{"editor", "viewer"}},
+		{map[string]interface{}{"admin": true}, []string{"admin"}},
+		{map[string]interface{}{"admin": true, "roles": []interface{}{"admin"}}, []string{"admin"}},
+		{map[string]interface{}{"admin": false}, nil},
+		{map[string]interface{}{}, nil},
+	}
+
+	for _, test := range tests {
+		if output := ClaimRoles(test.input); !reflect.DeepEqual(output, test.expected) {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestRequireMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetRolePermissions(RolePermissions{"editor": {"orders:write"}})
+	defer SetRolePermissions(RolePermissions{AdminRole: {"*"}})
+
+	tt := []struct {
+		name     string
+		token    *fbauth.Token
+		handler  gin.HandlerFunc
+		expected int
+	}{
+		{"no token", nil, RequireRole("editor"), http.StatusUnauthorized},
+		{"role", &fbauth.Token{Claims: map[string]interface{}{"roles": []interface{}{"editor"}}}, RequireRole("editor"), http.StatusOK},
+		{"wrong role", &fbauth.Token{Claims: map[string]interface{}{"roles": []interface{}{"viewer"}}}, RequireRole("editor"), http.StatusForbidden},
+		{"permission", &fbauth.Token{Claims: map[string]interface{}{"roles": []interface{}{"editor"}}}, RequirePermission("orders:write"), http.StatusOK},
+		{"missing permission", &fbauth.Token{Claims: map[string]interface{}{"roles": []interface{}{"editor"}}}, RequirePermission("orders:delete"), http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		token := tc.token
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) {
+			if token != nil {
+				c.Set(FirebaseContextVal, token)
+			}
+		}, tc.handler, func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != tc.expected {
+			t.Errorf("%s failed; wanted: %d, got: %d", tc.name, tc.expected, w.Code)
+		}
+	}
+}
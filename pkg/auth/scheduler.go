@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	errs "github.com/pkg/errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const appEngineQueueHeader = "X-Appengine-Queuename"
+
+var ErrNotScheduler = errors.New("request not sent by a trusted scheduler")
+
+// SchedulerStrategy checks whether a request was sent by Cloud Scheduler, Cloud Tasks, Pub/Sub push or cron
+type SchedulerStrategy func(c *gin.Context) error
+
+// AppEngineHeaders trusts the X-Appengine-Cron and X-Appengine-Queuename headers
+// only safe on App Engine Standard which strips them from external requests, anyone can set them on Cloud Run or GCE
+func AppEngineHeaders() SchedulerStrategy {
+	return func(c *gin.Context) error {
+		if c.Request.Header.Get(cronExecutedHeader) == "true" || c.Request.Header.Get(appEngineQueueHeader) != "" {
+			return nil
+		}
+		return errs.Wrap(ErrNotScheduler, "missing app engine cron or queue header")
+	}
+}
+
+// GoogleServiceAccount trusts Google signed OIDC tokens for one of audiences issued to one of serviceAccounts
+// the audience is the one configured on the Scheduler job, task or push subscription (defaults to the target url)
+func GoogleServiceAccount(audiences []string, serviceAccounts ...string) SchedulerStrategy {
+	return ServiceAccount(NewOIDCVerifier(GoogleIssuers(audiences...)...), serviceAccounts...)
+}
+
+// ServiceAccount trusts tokens verified by v whose verified email is one of serviceAccounts
+// the claims are stored under OIDCContextVal
+func ServiceAccount(v *OIDCVerifier, serviceAccounts ...string) SchedulerStrategy {
+	return func(c *gin.Context) error {
+		token, ok := bearerToken(c)
+		if !ok {
+			return errs.Wrap(ErrNotScheduler, "missing bearer token")
+		}
+
+		claims, err := v.Verify(c, token)
+		if err != nil {
+			return err
+		}
+		if !claims.EmailVerified || !contains(serviceAccounts, claims.Email) {
+			return errs.Wrap(ErrNotScheduler, "service account not allowed: "+claims.Email)
+		}
+
+		c.Set(OIDCContextVal, claims)
+		return nil
+	}
+}
+
+// Gin middleware allowing requests accepted by any of strategies
+// e.g. AuthScheduler(GoogleServiceAccount([]string{"https://api.example.com/jobs"}, "scheduler@my-project.iam.gserviceaccount.com"))
+func AuthScheduler(strategies ...SchedulerStrategy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		var reasons []string
+		for _, s := range strategies {
+			err := s(c)
+			if err == nil {
+				auditRequest(c, start, AuditEvent{Strategy: StrategyScheduler, Outcome: OutcomeSuccess, Principal: schedulerPrincipal(c)})
+				c.Next()
+				return
+			}
+			log.Println("scheduler auth rejected:", err)
+			reasons = append(reasons, err.Error())
+		}
+
+		auditRequest(c, start, AuditEvent{Strategy: StrategyScheduler, Outcome: OutcomeFailure, Reason: strings.Join(reasons, "; ")})
+		abortUnauthorized(c)
+	}
+}
+
+// email of the service account if the request was verified by ServiceAccount
+func schedulerPrincipal(c *gin.Context) string {
+	if v, ok := c.Get(OIDCContextVal); ok {
+		return v.(*OIDCClaims).Email
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthScheduler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ti := newTestIssuer(t)
+	defer ti.server.Close()
+
+	const (
+		iss = "https://issuer.example.com"
+		aud = "https://api.example.com/jobs"
+		sa  = "scheduler@project.iam.gserviceaccount.com"
+	)
+	v := NewOIDCVerifier(Issuer{Issuer: iss, JWKSURL: ti.server.URL, Audiences: []string{aud}})
+
+	token := func(email string, verified bool) string {
+		claims := testClaims(iss, aud, time.Now().Add(time.Hour))
+		claims["email"] = email
+		claims["email_verified"] = verified
+		return "Bearer " + ti.sign(t, claims)
+	}
+
+	r := gin.New()
+	r.POST("/oidc", AuthScheduler(ServiceAccount(v, sa)), func(c *gin.Context) {
+		c.String(http.StatusOK, c.MustGet(OIDCContextVal).(*OIDCClaims).Email)
+	})
+	r.POST("/either", AuthScheduler(ServiceAccount(v, sa), AppEngineHeaders()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tt := []struct {
+		name     string
+		path     string
+		headers  map[string]string
+		expected int
+	}{
+		{"service account", "/oidc", map[string]string{"Authorization": token(sa, true)}, http.StatusOK},
+		{"other service account", "/oidc", map[string]string{"Authorization": token("other@project.iam.gserviceaccount.com", true)}, http.StatusUnauthorized},
+		{"unverified email", "/oidc", map[string]string{"Authorization": token(sa, false)}, http.StatusUnauthorized},
+		{"cron header without strategy", "/oidc", map[string]string{"X-Appengine-Cron": "true"}, http.StatusUnauthorized},
+		{"cron header", "/either", map[string]string{"X-Appengine-Cron": "true"}, http.StatusOK},
+		{"queue header", "/either", map[string]string{"X-Appengine-Queuename": "default"}, http.StatusOK},
+		{"none", "/either", nil, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+		}
+	}
+}
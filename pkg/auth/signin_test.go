@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeMinter struct{}
+
+func (fakeMinter) CustomTokenWithClaims(ctx context.Context, uid string, claims map[string]interface{}) (string, error) {
+	return "custom-" + uid, nil
+}
+
+// stand-in for identitytoolkit.googleapis.com
+func newSignInServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["returnSecureToken"] != true {
+			t.Errorf("test failed; wanted returnSecureToken, got: %v", body)
+		}
+
+		switch r.URL.Path {
+		case "/v1/accounts:signInWithPassword":
+			if body["password"] != "correct" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": {"code": 400, "message": "INVALID_PASSWORD"}}`))
+				return
+			}
+			w.Write([]byte(`{"idToken": "id-pw", "refreshToken": "refresh-pw", "expiresIn": "3600", "localId": "user-pw"}`))
+		case "/v1/accounts:signInWithCustomToken":
+			payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub": "` + body["token"].(string)[len("custom-"):] + `"}`))
+			w.Write([]byte(`{"idToken": "h.` + payload + `.s", "refreshToken": "refresh-ct", "expiresIn": "3600"}`))
+		case "/v1/accounts:signUp":
+			w.Write([]byte(`{"idToken": "id-anon", "refreshToken": "refresh-anon", "expiresIn": "3600", "localId": "anon-1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestSignIn(t *testing.T) {
+	server := newSignInServer(t)
+	defer server.Close()
+
+	client := NewRESTClient("web-key")
+	client.IdentityToolkitURL = server.URL
+	ctx := context.Background()
+
+	token, err := client.SignInWithPassword(ctx, "user@example.com", "correct")
+	if err != nil || token.UserID != "user-pw" || token.RefreshToken != "refresh-pw" {
+		t.Errorf("test failed; input: password, got: %v %v", token, err)
+	}
+
+	if _, err := client.SignInWithPassword(ctx, "user@example.com", "wrong"); !IsFirebaseError(err, ErrCodeInvalidPassword) {
+		t.Errorf("test failed; wanted: %v, got: %v", ErrCodeInvalidPassword, err)
+	}
+
+	token, err = client.ExchangeCustomToken(ctx, fakeMinter{}, "service-user", nil)
+	if err != nil || token.UserID != "service-user" {
+		t.Errorf("test failed; input: custom token, got: %v %v", token, err)
+	}
+
+	token, err = client.SignInAnonymously(ctx)
+	if err != nil || token.UserID != "anon-1" {
+		t.Errorf("test failed; input: anonymous, got: %v %v", token, err)
+	}
+
+	// a signed in token is used until it needs refreshing
+	if id, err := TokenSourceFrom(client, token).IDToken(ctx); err != nil || id != "id-anon" {
+		t.Errorf("test failed; wanted: %v, got: %v %v", "id-anon", id, err)
+	}
+}
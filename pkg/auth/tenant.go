@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	errs "github.com/pkg/errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TenantContextVal = "FIREBASE_TENANT"
+	TenantHeader     = "X-Tenant-ID"
+
+	StrategyTenantJWT = "tenant_jwt"
+
+	defaultMaxTenantClients = 1000
+)
+
+var (
+	ErrNoTenant      = errors.New("tenant not resolved")
+	ErrUnknownTenant = errors.New("tenant not allowed")
+)
+
+// TenantResolver returns the tenant of a request, or "" if it can't tell
+type TenantResolver func(c *gin.Context) string
+
+// TenantFromToken reads the firebase.tenant claim of the bearer token without verifying it
+// safe as the token is then verified by the client of that tenant, which rejects tokens of other tenants
+func TenantFromToken() TenantResolver {
+	return func(c *gin.Context) string {
+		token, ok := bearerToken(c)
+		if !ok {
+			return ""
+		}
+		segments := strings.Split(token, ".")
+		if len(segments) != 3 {
+			return ""
+		}
+
+		var claims struct {
+			Firebase struct {
+				Tenant string `json:"tenant"`
+			} `json:"firebase"`
+		}
+		if err := decodeSegment(segments[1], &claims); err != nil {
+			return ""
+		}
+		return claims.Firebase.Tenant
+	}
+}
+
+// TenantFromHeader reads the tenant id from header e.g. TenantHeader
+func TenantFromHeader(header string) TenantResolver {
+	return func(c *gin.Context) string {
+		return c.Request.Header.Get(header)
+	}
+}
+
+// TenantFromSubdomain maps the subdomain of baseDomain to a tenant e.g. acme.example.com with baseDomain example.com
+// tenants maps subdomains to tenant ids, nil uses the subdomain as the tenant id
+func TenantFromSubdomain(baseDomain string, tenants map[string]string) TenantResolver {
+	suffix := "." + strings.TrimPrefix(baseDomain, ".")
+	return func(c *gin.Context) string {
+		host := c.Request.Host
+		if i := strings.LastIndex(host, ":"); i > 0 && !strings.Contains(host[i:], "]") {
+			host = host[:i]
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+
+		sub := strings.TrimSuffix(host, suffix)
+		if strings.Contains(sub, ".") {
+			return ""
+		}
+		if tenants == nil {
+			return sub
+		}
+		return tenants[sub]
+	}
+}
+
+// TenantClients returns and caches the auth client of each tenant
+// tenant ids come from unverified input, so restrict them with an allowlist or rely on the bounded cache
+type TenantClients struct {
+	MaxClients int // clients kept, one is evicted when full, defaults to 1000
+
+	mu        sync.Mutex
+	clients   map[string]AuthClient
+	allowed   map[string]bool
+	newClient func(tenantID string) (AuthClient, error)
+}
+
+// NewTenantClients uses newClient to create the client of a tenant the first time it's needed
+// when allowed is set other tenant ids are rejected with ErrUnknownTenant
+func NewTenantClients(newClient func(tenantID string) (AuthClient, error), allowed ...string) *TenantClients {
+	t := &TenantClients{
+		MaxClients: defaultMaxTenantClients,
+		clients:    map[string]AuthClient{},
+		newClient:  newClient,
+	}
+	if len(allowed) > 0 {
+		t.allowed = map[string]bool{}
+		for _, id := range allowed {
+			t.allowed[id] = true
+		}
+	}
+	return t
+}
+
+// FirebaseTenants creates tenant clients with the Identity Platform tenant manager e.g. client.TenantManager
+func FirebaseTenants(tm *fbauth.TenantManager, allowed ...string) *TenantClients {
+	return NewTenantClients(func(tenantID string) (AuthClient, error) {
+		return tm.AuthForTenant(tenantID)
+	}, allowed...)
+}
+
+var _ AuthClient = (*fbauth.TenantClient)(nil)
+
+// ForTenant returns the client of tenantID
+func (t *TenantClients) ForTenant(tenantID string) (AuthClient, error) {
+	if tenantID == "" {
+		return nil, ErrNoTenant
+	}
+	if t.allowed != nil && !t.allowed[tenantID] {
+		return nil, errs.Wrap(ErrUnknownTenant, tenantID)
+	}
+
+	t.mu.Lock()
+	client, ok := t.clients[tenantID]
+	t.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	// created outside the lock so a slow tenant doesn't block the others
+	client, err := t.newClient(tenantID)
+	if err != nil {
+		return nil, errs.Wrap(err, "error creating tenant client "+tenantID)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.clients[tenantID]; ok {
+		return existing, nil
+	}
+	if t.MaxClients > 0 && len(t.clients) >= t.MaxClients {
+		for id := range t.clients {
+			delete(t.clients, id)
+			break
+		}
+	}
+	t.clients[tenantID] = client
+	return client, nil
+}
+
+// Gin middleware verifying the bearer token with the client of the tenant found by the first matching resolver
+// defaults to TenantFromToken, the token is stored under FirebaseContextVal and the tenant id under TenantContextVal
+func AuthTenantJWT(tenants *TenantClients, resolvers ...TenantResolver) gin.HandlerFunc {
+	if len(resolvers) == 0 {
+		resolvers = []TenantResolver{TenantFromToken()}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		tenantID := ""
+		for _, r := range resolvers {
+			if tenantID = r(c); tenantID != "" {
+				break
+			}
+		}
+
+		idToken, err := verifyTenantToken(c, tenants, tenantID)
+		if err != nil {
+			log.Println("tenant token rejected:", err)
+			auditRequest(c, start, AuditEvent{Strategy: StrategyTenantJWT, Outcome: OutcomeFailure, Reason: err.Error()})
+			abortUnauthorized(c)
+			return
+		}
+
+		auditRequest(c, start, AuditEvent{Strategy: StrategyTenantJWT, Outcome: OutcomeSuccess, Principal: tenantID + "/" + idToken.UID})
+		c.Set(FirebaseContextVal, idToken)
+		c.Set(TenantContextVal, tenantID)
+		c.Next()
+	}
+}
+
+func verifyTenantToken(c *gin.Context, tenants *TenantClients, tenantID string) (*fbauth.Token, error) {
+	client, err := tenants.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	idToken, err := client.VerifyIDToken(c, token)
+	if err != nil {
+		return nil, err
+	}
+	// tenant clients already check this, other TokenVerifiers may not
+	if idToken.Firebase.Tenant != tenantID {
+		return nil, errs.Wrap(ErrInvalidToken, "token issued for tenant "+idToken.Firebase.Tenant)
+	}
+	return idToken, nil
+}
+
+// GetTenant returns the tenant id stored by AuthTenantJWT
+func GetTenant(c *gin.Context) string {
+	return c.GetString(TenantContextVal)
+}
+
+// TenantMergeClaims is MergeClaims for a user of tenantID
+func TenantMergeClaims(ctx context.Context, tenants *TenantClients, tenantID string, uid string, claims map[string]interface{}, opts ClaimOptions) (map[string]interface{}, error) {
+	client, err := tenants.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return MergeClaims(ctx, client, uid, claims, opts)
+}
+
+// TenantRemoveClaims is RemoveClaims for a user of tenantID
+func TenantRemoveClaims(ctx context.Context, tenants *TenantClients, tenantID string, uid string, opts ClaimOptions, keys ...string) (map[string]interface{}, error) {
+	client, err := tenants.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return RemoveClaims(ctx, client, uid, opts, keys...)
+}
+
+// TenantElevateToAdmin is ElevateToAdmin for a user of tenantID
+func TenantElevateToAdmin(ctx context.Context, tenants *TenantClients, tenantID string, uid string, opts ...ClaimOptions) error {
+	client, err := tenants.ForTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	return ElevateToAdmin(ctx, client, uid, opts...)
+}
+
+// TenantRevokeAdmin is RevokeAdmin for a user of tenantID
+func TenantRevokeAdmin(ctx context.Context, tenants *TenantClients, tenantID string, uid string, opts ...ClaimOptions) error {
+	client, err := tenants.ForTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	return RevokeAdmin(ctx, client, uid, opts...)
+}
+
+// TenantSetUserRoles is SetUserRoles for a user of tenantID
+func TenantSetUserRoles(ctx context.Context, tenants *TenantClients, tenantID string, uid string, roles ...string) error {
+	client, err := tenants.ForTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	return SetUserRoles(ctx, client, uid, roles...)
+}
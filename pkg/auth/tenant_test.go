@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	errs "github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func newFakeTenants(ids ...string) (*TenantClients, map[string]*authtest.FakeClient) {
+	fakes := map[string]*authtest.FakeClient{}
+	for _, id := range ids {
+		f := authtest.NewFakeClient()
+		f.TenantID = id
+		fakes[id] = f
+	}
+	return NewTenantClients(func(tenantID string) (AuthClient, error) {
+		f, ok := fakes[tenantID]
+		if !ok {
+			return nil, errors.New("unknown tenant")
+		}
+		return f, nil
+	}), fakes
+}
+
+func TestAuthTenantJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tenants, fakes := newFakeTenants("acme", "globex")
+	acmeToken, _ := fakes["acme"].Mint("user-1", nil)
+	globexToken, _ := fakes["globex"].Mint("user-2", nil)
+	projectToken, _ := authtest.NewFakeClient().Mint("user-3", nil)
+
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, GetTenant(c))
+	}
+	r.GET("/token", AuthTenantJWT(tenants), handler)
+	r.GET("/header", AuthTenantJWT(tenants, TenantFromHeader(TenantHeader)), handler)
+
+	tt := []struct {
+		name     string
+		path     string
+		token    string
+		header   string
+		expected int
+		tenant   string
+	}{
+		{"tenant from token", "/token", acmeToken, "", http.StatusOK, "acme"},
+		{"other tenant from token", "/token", globexToken, "", http.StatusOK, "globex"},
+		{"project token", "/token", projectToken, "", http.StatusUnauthorized, ""},
+		{"no token", "/token", "", "", http.StatusUnauthorized, ""},
+		{"tenant from header", "/header", acmeToken, "acme", http.StatusOK, "acme"},
+		{"header mismatches token", "/header", acmeToken, "globex", http.StatusUnauthorized, ""},
+		{"unknown tenant", "/header", acmeToken, "initech", http.StatusUnauthorized, ""},
+		{"no header", "/header", acmeToken, "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		if tc.header != "" {
+			req.Header.Set(TenantHeader, tc.header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected || (tc.expected == http.StatusOK && w.Body.String() != tc.tenant) {
+			t.Errorf("test failed; input: %v, wanted: %v %v, got: %v %v", tc.name, tc.expected, tc.tenant, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestTenantFromSubdomain(t *testing.T) {
+	tt := []struct {
+		host     string
+		tenants  map[string]string
+		expected string
+	}{
+		{"acme.example.com", nil, "acme"},
+		{"acme.example.com:8080", nil, "acme"},
+		{"acme.example.com", map[string]string{"acme": "acme-x7k2p"}, "acme-x7k2p"},
+		{"globex.example.com", map[string]string{"acme": "acme-x7k2p"}, ""},
+		{"example.com", nil, ""},
+		{"a.b.example.com", nil, ""},
+		{"acme.example.org", nil, ""},
+	}
+
+	for _, tc := range tt {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "http://"+tc.host+"/", nil)
+
+		if got := TenantFromSubdomain("example.com", tc.tenants)(c); got != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.host, tc.expected, got)
+		}
+	}
+}
+
+func TestTenantSetUserRoles(t *testing.T) {
+	tenants, fakes := newFakeTenants("acme", "globex")
+	fakes["acme"].CreateUser("user-1", nil)
+	fakes["globex"].CreateUser("user-1", nil)
+
+	if err := TenantSetUserRoles(context.Background(), tenants, "acme", "user-1", "editor"); err != nil {
+		t.Fatalf("test failed; input: %v, wanted: %v, got: %v", "acme", nil, err)
+	}
+
+	acme, _ := fakes["acme"].GetUser(context.Background(), "user-1")
+	globex, _ := fakes["globex"].GetUser(context.Background(), "user-1")
+	if acme.CustomClaims[RolesClaim] == nil || globex.CustomClaims[RolesClaim] != nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v / %v", "acme", "roles on acme only", acme.CustomClaims, globex.CustomClaims)
+	}
+
+	if err := TenantSetUserRoles(context.Background(), tenants, "", "user-1", "editor"); err != ErrNoTenant {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "no tenant", ErrNoTenant, err)
+	}
+}
+
+func TestTenantClientsBounded(t *testing.T) {
+	created := 0
+	newClient := func(tenantID string) (AuthClient, error) {
+		created++
+		f := authtest.NewFakeClient()
+		f.TenantID = tenantID
+		return f, nil
+	}
+
+	allowed := NewTenantClients(newClient, "acme")
+	if _, err := allowed.ForTenant("acme"); err != nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "acme", nil, err)
+	}
+	if _, err := allowed.ForTenant("random-123"); errs.Cause(err) != ErrUnknownTenant || created != 1 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v (%v clients created)", "random-123", ErrUnknownTenant, err, created)
+	}
+
+	bounded := NewTenantClients(newClient)
+	bounded.MaxClients = 2
+	for i := 0; i < 10; i++ {
+		bounded.ForTenant("tenant-" + strconv.Itoa(i))
+	}
+	if len(bounded.clients) != 2 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "10 tenants", 2, len(bounded.clients))
+	}
+}
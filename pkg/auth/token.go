@@ -1,16 +1,16 @@
 package auth
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"context"
+	"strconv"
+	"sync"
+	"time"
 )
 
 const (
-	refreshURL = "https://securetoken.googleapis.com/v1/token"
+	defaultRefreshMargin = 5 * time.Minute
+	// lifetime of Firebase ID tokens, assumed when expires_in can't be read
+	defaultTokenLifetime = time.Hour
 )
 
 type RefreshResponse struct {
@@ -25,39 +25,109 @@ type RefreshResponse struct {
 type RefreshToken string
 type APIKey string
 
-//https://firebase.google.com/docs/reference/rest/auth
+// https://firebase.google.com/docs/reference/rest/auth
+// prefer RESTClient.Refresh or a TokenSource which take a context and cache the token
 func RefreshFirebaseToken(token RefreshToken, secret APIKey) (RefreshResponse, error) {
+	return NewRESTClient(string(secret)).refresh(context.Background(), string(token))
+}
+
+// IDToken is a Firebase ID token and the refresh token to renew it
+type IDToken struct {
+	IDToken      string
+	RefreshToken string
+	UserID       string
+	Expiry       time.Time
+}
 
-	b, err := json.Marshal(struct {
-		RefreshToken string `json:"refresh_token"`
-		GrantType    string `json:"grant_type"`
-	}{
-		RefreshToken: string(token),
-		GrantType:    "refresh_token",
-	})
+// Refresh exchanges a refresh token for a new ID token
+func (c *RESTClient) Refresh(ctx context.Context, refreshToken string) (*IDToken, error) {
+	r, err := c.refresh(ctx, refreshToken)
 	if err != nil {
-		return RefreshResponse{}, err
+		return nil, err
 	}
+	return newIDToken(r, time.Now()), nil
+}
 
-	resp, err := http.Post(fmt.Sprintf("%s?key=%s", refreshURL, secret), http.DetectContentType(b), bytes.NewReader(b))
-	if err != nil {
-		return RefreshResponse{}, err
+func newIDToken(r RefreshResponse, now time.Time) *IDToken {
+	return &IDToken{
+		IDToken:      r.IDToken,
+		RefreshToken: r.RefreshToken,
+		UserID:       r.UserID,
+		Expiry:       expiry(now, r.ExpiresIn),
 	}
+}
 
-	code := resp.StatusCode
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return RefreshResponse{}, err
+func (c *RESTClient) refresh(ctx context.Context, refreshToken string) (RefreshResponse, error) {
+	var r RefreshResponse
+	err := c.post(ctx, c.SecureTokenURL+"/v1/token", map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+	}, &r)
+	return r, err
+}
+
+// TokenSource returns a cached ID token, refreshing it shortly before it expires
+// it's safe for concurrent use, callers wait for a single refresh
+type TokenSource struct {
+	Margin time.Duration // refresh this long before expiry, defaults to 5 minutes
+
+	client *RESTClient
+	mu     sync.Mutex
+	token  *IDToken
+	now    func() time.Time
+}
+
+// NewTokenSource starts from refreshToken, the first call to Token fetches an ID token
+func NewTokenSource(client *RESTClient, refreshToken string) *TokenSource {
+	return TokenSourceFrom(client, &IDToken{RefreshToken: refreshToken})
+}
+
+// TokenSourceFrom starts from a token returned by sign in
+func TokenSourceFrom(client *RESTClient, token *IDToken) *TokenSource {
+	return &TokenSource{
+		Margin: defaultRefreshMargin,
+		client: client,
+		token:  token,
+		now:    time.Now,
 	}
+}
+
+// Token returns the cached token or refreshes it when it expires within Margin
+func (s *TokenSource) Token(ctx context.Context) (*IDToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if code != 200 {
-		return RefreshResponse{}, errors.New(fmt.Sprintf("status code not 200, code: %d, error: %v", code, string(body)))
+	if s.token.IDToken != "" && s.now().Add(s.Margin).Before(s.token.Expiry) {
+		return s.token, nil
 	}
 
-	var r RefreshResponse
-	if err := json.Unmarshal(body, &r); err != nil {
-		return RefreshResponse{}, err
+	r, err := s.client.refresh(ctx, s.token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	token := newIDToken(r, s.now())
+	// the refresh token is only rotated by some flows
+	if token.RefreshToken == "" {
+		token.RefreshToken = s.token.RefreshToken
 	}
+	s.token = token
+	return token, nil
+}
+
+// IDToken returns the current ID token string e.g. for an Authorization: Bearer header
+func (s *TokenSource) IDToken(ctx context.Context) (string, error) {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.IDToken, nil
+}
 
-	return r, nil
+// expires_in is a number of seconds sent as a string, defaultTokenLifetime is used when it isn't one
+func expiry(now time.Time, expiresIn string) time.Time {
+	secs, err := strconv.Atoi(expiresIn)
+	if err != nil || secs <= 0 {
+		return now.Add(defaultTokenLifetime)
+	}
+	return now.Add(time.Duration(secs) * time.Second)
 }
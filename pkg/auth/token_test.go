@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stand-in for securetoken.googleapis.com
+func newTokenServer(t *testing.T, refreshes *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/token" || r.Header.Get("X-Goog-Api-Key") != "web-key" || r.URL.Query().Get("key") != "" {
+			t.Errorf("test failed; unexpected request: %v %v", r.URL, r.Header)
+		}
+
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["refresh_token"] != "refresh-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": 400, "message": "INVALID_REFRESH_TOKEN", "status": "INVALID_ARGUMENT"}}`))
+			return
+		}
+
+		n := atomic.AddInt32(refreshes, 1)
+		json.NewEncoder(w).Encode(RefreshResponse{
+			ExpiresIn:    "3600",
+			TokenType:    "Bearer",
+			RefreshToken: "refresh-1",
+			IDToken:      "id-" + string(rune('0'+n)),
+			UserID:       "user-1",
+		})
+	}))
+}
+
+func TestTokenSource(t *testing.T) {
+	var refreshes int32
+	server := newTokenServer(t, &refreshes)
+	defer server.Close()
+
+	client := NewRESTClient("web-key")
+	client.SecureTokenURL = server.URL
+	ts := NewTokenSource(client, "refresh-1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := ts.IDToken(context.Background()); err != nil || token != "id-1" {
+				t.Errorf("test failed; wanted: %v, got: %v %v", "id-1", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if refreshes != 1 {
+		t.Errorf("test failed; wanted: %v refresh, got: %v", 1, refreshes)
+	}
+
+	// within the refresh margin
+	ts.now = func() time.Time { return time.Now().Add(56 * time.Minute) }
+	token, err := ts.Token(context.Background())
+	if err != nil || token.IDToken != "id-2" || token.UserID != "user-1" {
+		t.Errorf("test failed; wanted: %v, got: %v %v", "id-2", token, err)
+	}
+
+	// the new expiry is measured from the source's clock so the token isn't refreshed again
+	if token, err := ts.Token(context.Background()); err != nil || token.IDToken != "id-2" || refreshes != 2 {
+		t.Errorf("test failed; wanted: %v after %v refreshes, got: %v %v after %v", "id-2", 2, token, err, refreshes)
+	}
+}
+
+func TestRefreshError(t *testing.T) {
+	var refreshes int32
+	server := newTokenServer(t, &refreshes)
+	defer server.Close()
+
+	client := NewRESTClient("web-key")
+	client.SecureTokenURL = server.URL
+
+	_, err := client.Refresh(context.Background(), "revoked")
+	if !IsFirebaseError(err, ErrCodeInvalidRefreshToken) {
+		t.Errorf("test failed; wanted: %v, got: %v", ErrCodeInvalidRefreshToken, err)
+	}
+
+	var tests = []struct {
+		input    string
+		expected FirebaseError
+	}{
+		{`{"error": {"code": 400, "message": "TOKEN_EXPIRED"}}`, FirebaseError{400, "TOKEN_EXPIRED", ""}},
+		{`{"error": {"code": 400, "message": "TOO_MANY_ATTEMPTS_TRY_LATER : Try again later."}}`, FirebaseError{400, "TOO_MANY_ATTEMPTS_TRY_LATER", "Try again later."}},
+		{`<html>bad gateway</html>`, FirebaseError{400, "Bad Request", "<html>bad gateway</html>"}},
+	}
+	for _, test := range tests {
+		if output := parseFirebaseError(400, []byte(test.input)).(*FirebaseError); *output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, *output)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		input    string
+		expected time.Time
+	}{
+		{"3600", now.Add(time.Hour)},
+		{"300", now.Add(5 * time.Minute)},
+		{"", now.Add(defaultTokenLifetime)},
+		{"soon", now.Add(defaultTokenLifetime)},
+		{"-1", now.Add(defaultTokenLifetime)},
+	}
+
+	for _, test := range tests {
+		if output := expiry(now, test.input); !output.Equal(test.expected) {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
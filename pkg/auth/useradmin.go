@@ -0,0 +1,541 @@
+package auth
+
+import (
+	"context"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/internal/autherr"
+	errs "github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActionDisableUser    = "disable_user"
+	ActionEnableUser     = "enable_user"
+	ActionRevokeSessions = "revoke_sessions"
+	ActionImportUsers    = "import_users"
+
+	defaultUserPageSize = 100
+	maxUserPageSize     = 1000
+	maxUserImport       = 1000
+	maxUserSearchPages  = 10
+	// separates the Firebase page token from the offset within the page in search page tokens
+	searchTokenSeparator = "~"
+)
+
+var ErrUserNotFound = autherr.ErrUserNotFound
+
+// UserAdmin wraps the Firebase user management calls used by the admin API
+// so they can be faked in unit tests
+type UserAdmin interface {
+	UserManager
+	GetUserByEmail(ctx context.Context, email string) (*fbauth.UserRecord, error)
+	ListUsers(ctx context.Context, pageSize int, pageToken string) ([]*fbauth.UserRecord, string, error)
+	SetUserDisabled(ctx context.Context, uid string, disabled bool) (*fbauth.UserRecord, error)
+	ImportUsers(ctx context.Context, users []ImportUser) (*ImportUsersResponse, error)
+}
+
+// FirebaseUsers is the part of the Firebase SDK wrapped by UserAdminClient
+// satisfied by *fbauth.Client, *fbauth.TenantClient and *Client
+type FirebaseUsers interface {
+	UserManager
+	GetUserByEmail(ctx context.Context, email string) (*fbauth.UserRecord, error)
+	Users(ctx context.Context, nextPageToken string) *fbauth.UserIterator
+	UpdateUser(ctx context.Context, uid string, user *fbauth.UserToUpdate) (*fbauth.UserRecord, error)
+	ImportUsers(ctx context.Context, users []*fbauth.UserToImport, opts ...fbauth.UserImportOption) (*fbauth.UserImportResult, error)
+}
+
+var (
+	_ FirebaseUsers = (*fbauth.Client)(nil)
+	_ FirebaseUsers = (*fbauth.TenantClient)(nil)
+	_ FirebaseUsers = (*Client)(nil)
+)
+
+type UserAdminClient struct {
+	FirebaseUsers
+}
+
+// NewUserAdmin wraps a Firebase or tenant client in a UserAdmin
+func NewUserAdmin(client FirebaseUsers) UserAdmin {
+	return UserAdminClient{FirebaseUsers: client}
+}
+
+// ListUsers returns a page of users and the token of the next page, "" on the last page
+func (a UserAdminClient) ListUsers(ctx context.Context, pageSize int, pageToken string) ([]*fbauth.UserRecord, string, error) {
+	var page []*fbauth.ExportedUserRecord
+	next, err := iterator.NewPager(a.Users(ctx, ""), pageSize, pageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", errs.Wrap(err, "failed to list users")
+	}
+
+	users := make([]*fbauth.UserRecord, 0, len(page))
+	for _, u := range page {
+		users = append(users, u.UserRecord)
+	}
+	return users, next, nil
+}
+
+func (a UserAdminClient) SetUserDisabled(ctx context.Context, uid string, disabled bool) (*fbauth.UserRecord, error) {
+	return a.UpdateUser(ctx, uid, (&fbauth.UserToUpdate{}).Disabled(disabled))
+}
+
+// ImportUsers creates or overwrites up to 1000 users, passwords aren't imported
+func (a UserAdminClient) ImportUsers(ctx context.Context, users []ImportUser) (*ImportUsersResponse, error) {
+	toImport := make([]*fbauth.UserToImport, 0, len(users))
+	for _, u := range users {
+		toImport = append(toImport, u.toImport())
+	}
+
+	result, err := a.FirebaseUsers.ImportUsers(ctx, toImport)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to import users")
+	}
+
+	resp := &ImportUsersResponse{SuccessCount: result.SuccessCount, FailureCount: result.FailureCount}
+	for _, e := range result.Errors {
+		resp.Errors = append(resp.Errors, ImportError{Index: e.Index, Reason: e.Reason})
+	}
+	return resp, nil
+}
+
+// User is the JSON representation of a Firebase user returned by the admin API
+type User struct {
+	UID              string                 `json:"uid"`
+	Email            string                 `json:"email,omitempty"`
+	DisplayName      string                 `json:"displayName,omitempty"`
+	PhoneNumber      string                 `json:"phoneNumber,omitempty"`
+	PhotoURL         string                 `json:"photoUrl,omitempty"`
+	EmailVerified    bool                   `json:"emailVerified"`
+	Disabled         bool                   `json:"disabled"`
+	Roles            []string               `json:"roles"`
+	CustomClaims     map[string]interface{} `json:"customClaims,omitempty"`
+	CreatedAt        *time.Time             `json:"createdAt,omitempty"`
+	LastLoginAt      *time.Time             `json:"lastLoginAt,omitempty"`
+	TokensValidAfter *time.Time             `json:"tokensValidAfter,omitempty"`
+}
+
+func newUser(u *fbauth.UserRecord) User {
+	user := User{
+		EmailVerified:    u.EmailVerified,
+		Disabled:         u.Disabled,
+		Roles:            ClaimRoles(u.CustomClaims),
+		CustomClaims:     u.CustomClaims,
+		TokensValidAfter: millisTime(u.TokensValidAfterMillis),
+	}
+	if user.Roles == nil {
+		user.Roles = []string{}
+	}
+	if u.UserInfo != nil {
+		user.UID = u.UID
+		user.Email = u.Email
+		user.DisplayName = u.DisplayName
+		user.PhoneNumber = u.PhoneNumber
+		user.PhotoURL = u.PhotoURL
+	}
+	if u.UserMetadata != nil {
+		user.CreatedAt = millisTime(u.UserMetadata.CreationTimestamp)
+		user.LastLoginAt = millisTime(u.UserMetadata.LastLogInTimestamp)
+	}
+	return user
+}
+
+func millisTime(ms int64) *time.Time {
+	if ms == 0 {
+		return nil
+	}
+	t := time.Unix(0, ms*int64(time.Millisecond)).UTC()
+	return &t
+}
+
+type ListUsersResponse struct {
+	Users         []User `json:"users"`
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// ImportUser is a user to create with the bulk import
+// an existing user with the same uid loses its password and providers when overwritten
+type ImportUser struct {
+	UID           string                 `json:"uid" binding:"required"`
+	Email         string                 `json:"email,omitempty"`
+	DisplayName   string                 `json:"displayName,omitempty"`
+	PhoneNumber   string                 `json:"phoneNumber,omitempty"`
+	PhotoURL      string                 `json:"photoUrl,omitempty"`
+	EmailVerified bool                   `json:"emailVerified"`
+	Disabled      bool                   `json:"disabled"`
+	Roles         []string               `json:"roles,omitempty"`
+	CustomClaims  map[string]interface{} `json:"customClaims,omitempty"`
+}
+
+// custom claims of the imported user, Roles replaces any roles claim
+func (u ImportUser) claims() map[string]interface{} {
+	claims := map[string]interface{}{}
+	for k, v := range u.CustomClaims {
+		claims[k] = v
+	}
+	if len(u.Roles) > 0 {
+		claims[RolesClaim] = u.Roles
+	}
+	return claims
+}
+
+func (u ImportUser) toImport() *fbauth.UserToImport {
+	i := (&fbauth.UserToImport{}).UID(u.UID).EmailVerified(u.EmailVerified).Disabled(u.Disabled)
+	if u.Email != "" {
+		i.Email(u.Email)
+	}
+	if u.DisplayName != "" {
+		i.DisplayName(u.DisplayName)
+	}
+	if u.PhoneNumber != "" {
+		i.PhoneNumber(u.PhoneNumber)
+	}
+	if u.PhotoURL != "" {
+		i.PhotoURL(u.PhotoURL)
+	}
+	if claims := u.claims(); len(claims) > 0 {
+		i.CustomClaims(claims)
+	}
+	return i
+}
+
+type ImportUsersRequest struct {
+	Users     []ImportUser `json:"users" binding:"required,dive"`
+	Overwrite bool         `json:"overwrite"` // replace existing users, otherwise the import is rejected if any uid exists
+}
+
+type ImportUsersResponse struct {
+	SuccessCount int           `json:"successCount"`
+	FailureCount int           `json:"failureCount"`
+	Errors       []ImportError `json:"errors,omitempty"`
+	Replaced     []string      `json:"replaced,omitempty"` // uids of existing users which were overwritten
+}
+
+// ImportError is a user which failed to import, Index is its position in the request
+type ImportError struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// Gin middleware allowing users whose user record has the admin role or legacy admin claim (VerifyAdmin), must run after AuthJWT
+// reads the user record so admins revoked with RevokeAdmin are rejected before their ID token expires
+func RequireAdmin(client UserManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := contextToken(c)
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+
+		if err := VerifyAdmin(c, client, token.UID); err != nil {
+			log.Println("admin check failed for", token.UID, err)
+			auditRequest(c, time.Time{}, AuditEvent{Action: ActionAuthorize, Outcome: OutcomeDenied, Principal: token.UID, Reason: "not admin"})
+			abortForbidden(c)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// MountUserAdmin adds the user management API to r under path, protected by AuthJWT and RequireAdmin
+//
+//	GET    /users?pageSize=&pageToken=&q=   list users, q is an email or searches uid, email and name
+//	POST   /users                           bulk import (ImportUsersRequest), 409 with the existing uids unless overwrite is set
+//	GET    /users/:uid
+//	POST   /users/:uid/disable
+//	POST   /users/:uid/enable
+//	PUT    /users/:uid/roles                replace roles (SetRolesRequest)
+//	DELETE /users/:uid/roles                remove every role including admin
+//	POST   /users/:uid/revoke               revoke refresh tokens and sessions
+func MountUserAdmin(r gin.IRouter, path string, verifier TokenVerifier, users UserAdmin) *gin.RouterGroup {
+	g := r.Group(path, AuthJWT(verifier), RequireAdmin(users))
+
+	g.GET("/users", listUsers(users))
+	g.POST("/users", importUsers(users))
+	g.GET("/users/:uid", getUser(users))
+	g.POST("/users/:uid/disable", setUserDisabled(users, true))
+	g.POST("/users/:uid/enable", setUserDisabled(users, false))
+	g.PUT("/users/:uid/roles", setRoles(users))
+	g.DELETE("/users/:uid/roles", clearRoles(users))
+	g.POST("/users/:uid/revoke", revokeSessions(users))
+	return g
+}
+
+func listUsers(users UserAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultUserPageSize)))
+		if err != nil || pageSize < 1 || pageSize > maxUserPageSize {
+			abortBadRequest(c)
+			return
+		}
+		q := strings.TrimSpace(c.Query("q"))
+
+		// Firebase can only look up exact emails, anything else is matched while paging
+		if strings.Contains(q, "@") {
+			u, err := users.GetUserByEmail(c, q)
+			if err != nil && !isUserNotFound(err) {
+				abortUserAdmin(c, err)
+				return
+			}
+			resp := ListUsersResponse{Users: []User{}}
+			if u != nil {
+				resp.Users = append(resp.Users, newUser(u))
+			}
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+
+		resp, err := searchUsers(c, users, strings.ToLower(q), pageSize, c.Query("pageToken"))
+		if err != nil {
+			abortUserAdmin(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// pages through full pages of users until pageSize match q or maxUserSearchPages were read
+// the page token is the Firebase page token, with the offset of the next unread user when a page wasn't finished
+func searchUsers(ctx context.Context, users UserAdmin, q string, pageSize int, pageToken string) (ListUsersResponse, error) {
+	resp := ListUsersResponse{Users: []User{}}
+	if q == "" {
+		page, next, err := users.ListUsers(ctx, pageSize, pageToken)
+		if err != nil {
+			return resp, err
+		}
+		for _, u := range page {
+			resp.Users = append(resp.Users, newUser(u))
+		}
+		resp.NextPageToken = next
+		return resp, nil
+	}
+
+	token, offset := parseSearchToken(pageToken)
+	for pages := 0; pages < maxUserSearchPages; pages++ {
+		page, next, err := users.ListUsers(ctx, maxUserPageSize, token)
+		if err != nil {
+			return resp, err
+		}
+
+		for i := offset; i < len(page); i++ {
+			if !userMatches(page[i], q) {
+				continue
+			}
+			resp.Users = append(resp.Users, newUser(page[i]))
+			if len(resp.Users) == pageSize {
+				if i+1 < len(page) {
+					resp.NextPageToken = token + searchTokenSeparator + strconv.Itoa(i+1)
+				} else {
+					resp.NextPageToken = next
+				}
+				return resp, nil
+			}
+		}
+
+		resp.NextPageToken = next
+		if next == "" {
+			return resp, nil
+		}
+		token, offset = next, 0
+	}
+	return resp, nil
+}
+
+func parseSearchToken(pageToken string) (string, int) {
+	i := strings.LastIndex(pageToken, searchTokenSeparator)
+	if i < 0 {
+		return pageToken, 0
+	}
+	offset, err := strconv.Atoi(pageToken[i+1:])
+	if err != nil || offset < 0 {
+		return pageToken, 0
+	}
+	return pageToken[:i], offset
+}
+
+func userMatches(u *fbauth.UserRecord, q string) bool {
+	if u.UserInfo == nil {
+		return false
+	}
+	for _, v := range []string{u.UID, u.Email, u.DisplayName} {
+		if strings.Contains(strings.ToLower(v), q) {
+			return true
+		}
+	}
+	return false
+}
+
+func getUser(users UserAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := users.GetUser(c, c.Param("uid"))
+		if err != nil {
+			abortUserAdmin(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, newUser(u))
+	}
+}
+
+func setUserDisabled(users UserAdmin, disabled bool) gin.HandlerFunc {
+	action := ActionEnableUser
+	if disabled {
+		action = ActionDisableUser
+	}
+
+	return func(c *gin.Context) {
+		uid := c.Param("uid")
+		u, err := users.SetUserDisabled(c, uid, disabled)
+		auditChange(c, AuditEvent{Action: action, Outcome: auditOutcome(err), Target: uid, Reason: auditReason(err)})
+		if err != nil {
+			abortUserAdmin(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, newUser(u))
+	}
+}
+
+func setRoles(users UserAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SetRolesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortBadRequest(c)
+			return
+		}
+		updateRoles(c, users, req.Roles...)
+	}
+}
+
+func clearRoles(users UserAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateRoles(c, users)
+	}
+}
+
+func updateRoles(c *gin.Context, users UserAdmin, roles ...string) {
+	uid := c.Param("uid")
+	// the request's roles are all the user has, so a legacy admin loses admin unless it's listed
+	if err := ReplaceUserRoles(c, users, uid, roles...); err != nil {
+		abortUserAdmin(c, err)
+		return
+	}
+
+	u, err := users.GetUser(c, uid)
+	if err != nil {
+		abortUserAdmin(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newUser(u))
+}
+
+func revokeSessions(users UserAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid := c.Param("uid")
+		err := users.RevokeRefreshTokens(c, uid)
+		auditChange(c, AuditEvent{Action: ActionRevokeSessions, Outcome: auditOutcome(err), Target: uid, Reason: auditReason(err)})
+		if err != nil {
+			abortUserAdmin(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
+
+func importUsers(users UserAdmin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ImportUsersRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Users) == 0 || len(req.Users) > maxUserImport {
+			abortBadRequest(c)
+			return
+		}
+		for _, u := range req.Users {
+			if err := ValidateClaims(u.claims()); err != nil {
+				abortBadRequest(c)
+				return
+			}
+		}
+
+		// the import can't keep passwords or providers, so don't replace users by accident
+		existing, err := existingUsers(c, users, req.Users)
+		if err != nil {
+			abortUserAdmin(c, err)
+			return
+		}
+		if len(existing) > 0 && !req.Overwrite {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"code":     http.StatusConflict,
+				"message":  http.StatusText(http.StatusConflict),
+				"existing": existing,
+			})
+			return
+		}
+
+		resp, err := users.ImportUsers(c, req.Users)
+		reason := auditReason(err)
+		if err == nil && len(existing) > 0 {
+			reason = "replaced " + strings.Join(existing, ",")
+		}
+		auditChange(c, AuditEvent{Action: ActionImportUsers, Outcome: auditOutcome(err), Target: strconv.Itoa(len(req.Users)) + " users", Reason: reason})
+		if err != nil {
+			abortUserAdmin(c, err)
+			return
+		}
+		resp.Replaced = existing
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// uids of users which already exist
+func existingUsers(ctx context.Context, users UserAdmin, imports []ImportUser) ([]string, error) {
+	var existing []string
+	for _, u := range imports {
+		_, err := users.GetUser(ctx, u.UID)
+		if err == nil {
+			existing = append(existing, u.UID)
+			continue
+		}
+		if !isUserNotFound(err) {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
+
+func isUserNotFound(err error) bool {
+	cause := errs.Cause(err)
+	return cause == ErrUserNotFound || fbauth.IsUserNotFound(cause)
+}
+
+func abortUserAdmin(c *gin.Context, err error) {
+	if isUserNotFound(err) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": http.StatusText(http.StatusNotFound),
+		})
+		return
+	}
+
+	log.Println("user admin request failed:", err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+		"code":    http.StatusInternalServerError,
+		"message": http.StatusText(http.StatusInternalServerError),
+	})
+}
+
+func abortBadRequest(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+		"code":    http.StatusBadRequest,
+		"message": http.StatusText(http.StatusBadRequest),
+	})
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/mousybusiness/googlecloudgo/pkg/auth/authtest"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+type fakeUserAdmin struct {
+	*authtest.FakeClient
+	uids  []string
+	lists int
+}
+
+func (f *fakeUserAdmin) add(uid string, email string, claims map[string]interface{}) {
+	u := f.CreateUser(uid, claims)
+	u.Email = email
+	f.uids = append(f.uids, uid)
+	sort.Strings(f.uids)
+}
+
+func (f *fakeUserAdmin) GetUserByEmail(ctx context.Context, email string) (*fbauth.UserRecord, error) {
+	for _, uid := range f.uids {
+		if u, _ := f.GetUser(ctx, uid); u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// page tokens are offsets into the sorted uids
+func (f *fakeUserAdmin) ListUsers(ctx context.Context, pageSize int, pageToken string) ([]*fbauth.UserRecord, string, error) {
+	f.lists++
+	start, _ := strconv.Atoi(pageToken)
+	var users []*fbauth.UserRecord
+	for i := start; i < len(f.uids) && len(users) < pageSize; i++ {
+		u, _ := f.GetUser(ctx, f.uids[i])
+		users = append(users, u)
+	}
+
+	next := ""
+	if end := start + len(users); end < len(f.uids) {
+		next = strconv.Itoa(end)
+	}
+	return users, next, nil
+}
+
+func (f *fakeUserAdmin) SetUserDisabled(ctx context.Context, uid string, disabled bool) (*fbauth.UserRecord, error) {
+	if err := f.SetDisabled(uid, disabled); err != nil {
+		return nil, err
+	}
+	return f.GetUser(ctx, uid)
+}
+
+func (f *fakeUserAdmin) ImportUsers(ctx context.Context, users []ImportUser) (*ImportUsersResponse, error) {
+	for _, u := range users {
+		f.add(u.UID, u.Email, u.claims())
+	}
+	return &ImportUsersResponse{SuccessCount: len(users)}, nil
+}
+
+func TestMountUserAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := &fakeUserAdmin{FakeClient: authtest.NewFakeClient()}
+	users.add("admin-1", "admin@example.com", map[string]interface{}{"admin": true})
+	users.add("user-1", "alice@example.com", map[string]interface{}{RolesClaim: []string{"editor"}})
+	users.add("user-2", "bob@example.com", nil)
+	users.add("user-3", "carol@example.org", nil)
+	users.add("user-5", "erin@example.com", map[string]interface{}{RolesClaim: []string{AdminRole}})
+	users.add("user-6", "frank@example.com", map[string]interface{}{"admin": true})
+
+	adminToken, _ := users.Mint("admin-1", nil)
+	userToken, _ := users.Mint("user-1", nil)
+	roleAdminToken, _ := users.Mint("user-5", nil)
+
+	r := gin.New()
+	MountUserAdmin(r, "/admin", users, users)
+
+	tt := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		body     string
+		expected int
+		uids     []string
+	}{
+		{"no token", http.MethodGet, "/admin/users", "", "", http.StatusUnauthorized, nil},
+		{"not admin", http.MethodGet, "/admin/users", userToken, "", http.StatusForbidden, nil},
+		{"list", http.MethodGet, "/admin/users?pageSize=2", adminToken, "", http.StatusOK, []string{"admin-1", "user-1"}},
+		{"next page", http.MethodGet, "/admin/users?pageSize=2&pageToken=2", adminToken, "", http.StatusOK, []string{"user-2", "user-3"}},
+		{"admin role", http.MethodGet, "/admin/users/user-1", roleAdminToken, "", http.StatusOK, []string{"user-1"}},
+		{"bad page size", http.MethodGet, "/admin/users?pageSize=0", adminToken, "", http.StatusBadRequest, nil},
+		{"search email", http.MethodGet, "/admin/users?q=bob@example.com", adminToken, "", http.StatusOK, []string{"user-2"}},
+		{"search missing email", http.MethodGet, "/admin/users?q=dave@example.com", adminToken, "", http.StatusOK, []string{}},
+		{"search", http.MethodGet, "/admin/users?pageSize=1&q=example.org", adminToken, "", http.StatusOK, []string{"user-3"}},
+		{"search within a page", http.MethodGet, "/admin/users?pageSize=1&q=example.com&pageToken=~1", adminToken, "", http.StatusOK, []string{"user-1"}},
+		{"get", http.MethodGet, "/admin/users/user-1", adminToken, "", http.StatusOK, []string{"user-1"}},
+		{"get missing", http.MethodGet, "/admin/users/user-9", adminToken, "", http.StatusNotFound, nil},
+		{"disable", http.MethodPost, "/admin/users/user-2/disable", adminToken, "", http.StatusOK, []string{"user-2"}},
+		{"set roles", http.MethodPut, "/admin/users/user-2/roles", adminToken, `{"roles":["viewer"]}`, http.StatusOK, []string{"user-2"}},
+		{"set roles missing", http.MethodPut, "/admin/users/user-9/roles", adminToken, `{"roles":["viewer"]}`, http.StatusNotFound, nil},
+		{"clear roles", http.MethodDelete, "/admin/users/user-1/roles", adminToken, "", http.StatusOK, []string{"user-1"}},
+		{"clear legacy admin", http.MethodDelete, "/admin/users/user-6/roles", adminToken, "", http.StatusOK, []string{"user-6"}},
+		{"revoke", http.MethodPost, "/admin/users/user-1/revoke", adminToken, "", http.StatusOK, nil},
+		{"import", http.MethodPost, "/admin/users", adminToken, `{"users":[{"uid":"user-4","roles":["viewer"]}]}`, http.StatusOK, nil},
+		{"import without uid", http.MethodPost, "/admin/users", adminToken, `{"users":[{"email":"x@example.com"}]}`, http.StatusBadRequest, nil},
+		{"import existing", http.MethodPost, "/admin/users", adminToken, `{"users":[{"uid":"user-7"},{"uid":"user-3","roles":["viewer"]}]}`, http.StatusConflict, nil},
+		{"import overwrite", http.MethodPost, "/admin/users", adminToken, `{"overwrite":true,"users":[{"uid":"user-3","email":"carol@example.org","roles":["viewer"]}]}`, http.StatusOK, nil},
+		{"import reserved claim", http.MethodPost, "/admin/users", adminToken, `{"users":[{"uid":"user-5","customClaims":{"sub":"x"}}]}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.expected, w.Code)
+			continue
+		}
+		if tc.uids == nil {
+			continue
+		}
+
+		var got []string
+		var list ListUsersResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err == nil && list.Users != nil {
+			for _, u := range list.Users {
+				got = append(got, u.UID)
+			}
+		} else {
+			var u User
+			json.Unmarshal(w.Body.Bytes(), &u)
+			got = []string{u.UID}
+		}
+		if got == nil {
+			got = []string{}
+		}
+		if !reflect.DeepEqual(got, tc.uids) {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, tc.uids, got)
+		}
+	}
+
+	ctx := context.Background()
+	if err := VerifyAdmin(ctx, users, "user-6"); err == nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "cleared legacy admin", "not admin", err)
+	}
+
+	// searches read full pages rather than one user per call
+	users.lists = 0
+	req := httptest.NewRequest(http.MethodGet, "/admin/users?pageSize=1&q=nobody", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if users.lists != 1 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "search without matches", 1, users.lists)
+	}
+
+	u2, _ := users.GetUser(ctx, "user-2")
+	if !u2.Disabled || !reflect.DeepEqual(ClaimRoles(u2.CustomClaims), []string{"viewer"}) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "user-2", "disabled viewer", u2.Disabled, u2.CustomClaims)
+	}
+	u1, _ := users.GetUser(ctx, "user-1")
+	if len(ClaimRoles(u1.CustomClaims)) != 0 || u1.TokensValidAfterMillis == 0 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "user-1", "no roles and revoked", u1.CustomClaims, u1.TokensValidAfterMillis)
+	}
+	// overwritten uids are reported
+	req = httptest.NewRequest(http.MethodPost, "/admin/users", bytes.NewBufferString(`{"overwrite":true,"users":[{"uid":"user-2"},{"uid":"user-8"}]}`))
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var imported ImportUsersResponse
+	json.Unmarshal(w.Body.Bytes(), &imported)
+	if !reflect.DeepEqual(imported.Replaced, []string{"user-2"}) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "overwrite user-2 and user-8", []string{"user-2"}, imported.Replaced)
+	}
+
+	if _, err := users.GetUser(ctx, "user-7"); err == nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "user-7", "rejected import", "imported")
+	}
+	if u3, _ := users.GetUser(ctx, "user-3"); !reflect.DeepEqual(ClaimRoles(u3.CustomClaims), []string{"viewer"}) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "user-3", "overwritten viewer", u3.CustomClaims)
+	}
+	if u4, err := users.GetUser(ctx, "user-4"); err != nil || !reflect.DeepEqual(ClaimRoles(u4.CustomClaims), []string{"viewer"}) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "user-4", "imported viewer", u4, err)
+	}
+}
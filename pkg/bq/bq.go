@@ -16,7 +16,28 @@ var (
 	tableName     = os.Getenv("TABLE_NAME")
 )
 
+// query BigQuery and return each row joined with commas
 func QueryBQ(query string) ([]string, error) {
+	rows, err := QueryRows(query)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	// convert []bigquery.Value to []string - there has to be a better way...
+	for _, v := range rows {
+		var s []string
+		for _, vv := range v {
+			s = append(s, vv.(string))
+		}
+
+		out = append(out, strings.Join(s, ","))
+	}
+	return out, nil
+}
+
+// query BigQuery and return the raw row values
+func QueryRows(query string) ([][]bigquery.Value, error) {
 	log.Println("querying BigQuery with:", query)
 
 	// check query is sane
@@ -57,18 +78,31 @@ func QueryBQ(query string) ([]string, error) {
 	}
 
 	log.Println(len(rows), "items returned from BQ")
+	return rows, nil
+}
 
-	out := []string{}
-	// convert []bigquery.Value to []string - there has to be a better way...
-	for _, v := range rows {
-		var s []string
-		for _, vv := range v {
-			s = append(s, vv.(string))
-		}
+// create a BigQuery client for PROJECT, callers must Close it
+func NewClient(ctx context.Context) (*bigquery.Client, error) {
+	client, err := bigquery.NewClient(ctx, dataProjectId)
+	if err != nil {
+		return nil, errs.Wrap(err, "error creating BQ client")
+	}
+	return client, nil
+}
 
-		out = append(out, strings.Join(s, ","))
+// stream rows into dataset.table, rows must be a struct, struct pointer or a slice of them
+// creates a client per call, hold a client from NewClient when inserting repeatedly
+func InsertRows(ctx context.Context, dataset string, table string, rows interface{}) error {
+	client, err := NewClient(ctx)
+	if err != nil {
+		return err
 	}
-	return out, nil
+	defer client.Close()
+
+	if err := client.Dataset(dataset).Table(table).Inserter().Put(ctx, rows); err != nil {
+		return errs.Wrap(err, "error inserting BQ rows")
+	}
+	return nil
 }
 
 // returned 'SELECT *' when nil or empty columns are provided
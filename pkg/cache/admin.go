@@ -0,0 +1,122 @@
+package cache
+
+import (
+	redisman "cloud.google.com/go/redis/apiv1beta1"
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	errs "github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// InstanceAdmin wraps the Cloud Redis admin calls used by this package
+// so the long running operations can be faked in unit tests
+type InstanceAdmin interface {
+	GetInstance(ctx context.Context, name string) (*redispb.Instance, error)
+	ListInstances(ctx context.Context, parent string) ([]*redispb.Instance, error)
+	CreateInstance(ctx context.Context, parent string, id string, instance *redispb.Instance) (*redispb.Instance, error)
+	UpdateInstance(ctx context.Context, instance *redispb.Instance, paths ...string) (*redispb.Instance, error)
+	DeleteInstance(ctx context.Context, name string) error
+	GetAuthString(ctx context.Context, name string) (string, error)
+}
+
+type AdminClient struct {
+	c *redisman.CloudRedisClient
+}
+
+// NewAdminClient creates a Cloud Redis client and wraps it in an InstanceAdmin
+// returns ErrDirectMode when connecting directly to redis (see DirectConfig)
+func NewAdminClient(ctx context.Context) (InstanceAdmin, error) {
+	if DirectMode() {
+		return nil, ErrDirectMode
+	}
+
+	c, err := redisman.NewCloudRedisClient(ctx)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to create cloud redis client")
+	}
+	return AdminClient{c: c}, nil
+}
+
+func (a AdminClient) Client() *redisman.CloudRedisClient {
+	return a.c
+}
+
+// GetInstance returns the instance with the full resource name
+func (a AdminClient) GetInstance(ctx context.Context, name string) (*redispb.Instance, error) {
+	return a.c.GetInstance(ctx, &redispb.GetInstanceRequest{Name: name})
+}
+
+// ListInstances returns every instance in the location, following all pages
+func (a AdminClient) ListInstances(ctx context.Context, parent string) ([]*redispb.Instance, error) {
+	it := a.c.ListInstances(ctx, &redispb.ListInstancesRequest{Parent: parent})
+
+	var out []*redispb.Instance
+	for {
+		instance, err := it.Next()
+		if err == iterator.Done {
+			return out, nil
+		}
+		if err != nil {
+			return out, errs.Wrap(err, "failed to list instances")
+		}
+		out = append(out, instance)
+	}
+}
+
+// CreateInstance creates the instance and waits for the operation to complete
+func (a AdminClient) CreateInstance(ctx context.Context, parent string, id string, instance *redispb.Instance) (*redispb.Instance, error) {
+	op, err := a.c.CreateInstance(ctx, &redispb.CreateInstanceRequest{
+		Parent:     parent,
+		InstanceId: id,
+		Instance:   instance,
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, "error while trying to create cache")
+	}
+
+	created, err := op.Wait(ctx)
+	if err != nil {
+		return nil, errs.Wrap(err, "error while waiting for create")
+	}
+	return created, nil
+}
+
+// UpdateInstance updates the fields named in paths (e.g. memory_size_gb) and waits for the operation to complete
+func (a AdminClient) UpdateInstance(ctx context.Context, instance *redispb.Instance, paths ...string) (*redispb.Instance, error) {
+	op, err := a.c.UpdateInstance(ctx, &redispb.UpdateInstanceRequest{
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+		Instance:   instance,
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, "error while trying to update cache")
+	}
+
+	updated, err := op.Wait(ctx)
+	if err != nil {
+		return nil, errs.Wrap(err, "error while waiting for update")
+	}
+	return updated, nil
+}
+
+// DeleteInstance deletes the instance and waits for the operation to complete
+func (a AdminClient) DeleteInstance(ctx context.Context, name string) error {
+	op, err := a.c.DeleteInstance(ctx, &redispb.DeleteInstanceRequest{Name: name})
+	if err != nil {
+		return errs.Wrap(err, "error while trying to delete cache")
+	}
+
+	if err := op.Wait(ctx); err != nil {
+		return errs.Wrap(err, "error while waiting for delete")
+	}
+	return nil
+}
+
+// GetAuthString returns the AUTH string of an instance with AUTH enabled
+func (a AdminClient) GetAuthString(ctx context.Context, name string) (string, error) {
+	auth, err := a.c.GetInstanceAuthString(ctx, &redispb.GetInstanceAuthStringRequest{Name: name})
+	if err != nil {
+		return "", errs.Wrap(err, "failed to get auth string")
+	}
+	return auth.GetAuthString(), nil
+}
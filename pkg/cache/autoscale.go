@@ -0,0 +1,317 @@
+package cache
+
+import (
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	"fmt"
+	errs "github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"sync"
+	"time"
+)
+
+const maxDecisionHistory = 100
+
+type Action string
+
+const (
+	ActionNone   Action = "none"
+	ActionCreate Action = "create"
+	ActionResize Action = "resize"
+	ActionDelete Action = "delete"
+)
+
+// ScalePolicy describes when the Autoscaler creates, resizes and deletes the instance
+// rates are requests per second
+type ScalePolicy struct {
+	CreateRate  float64 // create a missing instance at or above this rate
+	DeleteRate  float64 // delete the instance below this rate (outside of schedule windows)
+	GrowRate    float64 // grow memory by StepGb at or above this rate
+	ShrinkRate  float64 // shrink memory by StepGb below this rate
+	MinMemoryGb int32   // defaults to 1
+	MaxMemoryGb int32   // 0 means no upper limit
+	StepGb      int32   // defaults to 1
+	Windows     []ScheduleWindow
+	Cooldown    time.Duration // minimum time between two applied actions
+}
+
+// ScheduleWindow keeps the instance alive with at least MemoryGb while active
+// Start and End are offsets from midnight UTC, an empty Days matches every day
+type ScheduleWindow struct {
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	MemoryGb int32
+}
+
+// Contains reports whether t falls inside the window
+func (w ScheduleWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	if len(w.Days) > 0 {
+		found := false
+		for _, d := range w.Days {
+			if d == t.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := t.Sub(midnight)
+	return offset >= w.Start && offset < w.End
+}
+
+// Decision is a single evaluation of the policy, kept for dry-run review
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Action  Action    `json:"action"`
+	Rate    float64   `json:"rate"`
+	FromGb  int32     `json:"fromGb"`
+	ToGb    int32     `json:"toGb"`
+	Reason  string    `json:"reason"`
+	Applied bool      `json:"applied"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Autoscaler creates, resizes and deletes the Memorystore instance according to a ScalePolicy
+type Autoscaler struct {
+	Policy     ScalePolicy
+	Admin      InstanceAdmin
+	Parent     string
+	InstanceID string
+	Template   *redispb.Instance // used when creating, MemorySizeGb is overwritten - see InstanceSpec.Instance
+	DryRun     bool              // record decisions without applying them
+
+	mu        sync.Mutex
+	last      time.Time
+	decisions []Decision
+	now       func() time.Time
+}
+
+// NewAutoscaler returns an Autoscaler for the instance configured with REGION and MEMORYSTORE_INSTANCE
+func NewAutoscaler(admin InstanceAdmin, policy ScalePolicy) *Autoscaler {
+	spec := SpecFromEnv()
+	return &Autoscaler{
+		Policy:     policy,
+		Admin:      admin,
+		Parent:     spec.Parent(),
+		InstanceID: spec.InstanceID,
+		Template:   spec.Instance(),
+		now:        time.Now,
+	}
+}
+
+// Decide evaluates the policy against the current instance (nil if it doesn't exist) without applying anything
+func (a *Autoscaler) Decide(instance *redispb.Instance, rate float64, now time.Time) Decision {
+	p := a.Policy
+	min, step := p.MinMemoryGb, p.StepGb
+	if min <= 0 {
+		min = 1
+	}
+	if step <= 0 {
+		step = 1
+	}
+
+	// highest memory requirement of the active schedule windows
+	var floor int32
+	for _, w := range p.Windows {
+		if w.Contains(now) && w.MemoryGb > floor {
+			floor = w.MemoryGb
+		}
+	}
+
+	d := Decision{Time: now, Action: ActionNone, Rate: rate}
+	if instance != nil {
+		d.FromGb = instance.GetMemorySizeGb()
+		d.ToGb = d.FromGb
+	}
+
+	a.mu.Lock()
+	last := a.last
+	a.mu.Unlock()
+	if p.Cooldown > 0 && !last.IsZero() && now.Sub(last) < p.Cooldown {
+		d.Reason = "cooling down"
+		return d
+	}
+
+	if instance == nil {
+		size := min
+		if floor > size {
+			size = floor
+		}
+
+		switch {
+		case floor > 0:
+			d.Action, d.ToGb, d.Reason = ActionCreate, size, "inside schedule window"
+		case rate >= p.CreateRate && p.CreateRate > 0:
+			d.Action, d.ToGb, d.Reason = ActionCreate, size, fmt.Sprintf("rate %.2f at or above %.2f", rate, p.CreateRate)
+		default:
+			d.Reason = "no instance required"
+		}
+		return d
+	}
+
+	if instance.GetState() != redispb.Instance_READY {
+		d.Reason = fmt.Sprintf("instance is %s", instance.GetState())
+		return d
+	}
+
+	if floor == 0 && rate < p.DeleteRate {
+		d.Action, d.ToGb, d.Reason = ActionDelete, 0, fmt.Sprintf("rate %.2f below %.2f", rate, p.DeleteRate)
+		return d
+	}
+
+	target := d.FromGb
+	switch {
+	case rate >= p.GrowRate && p.GrowRate > 0:
+		target += step
+		if p.MaxMemoryGb > 0 && target > p.MaxMemoryGb {
+			target = p.MaxMemoryGb
+		}
+		d.Reason = fmt.Sprintf("rate %.2f at or above %.2f", rate, p.GrowRate)
+	case rate < p.ShrinkRate:
+		target -= step
+		if target < min {
+			target = min
+		}
+		d.Reason = fmt.Sprintf("rate %.2f below %.2f", rate, p.ShrinkRate)
+	}
+	if target < floor {
+		target = floor
+		d.Reason = "inside schedule window"
+	}
+
+	if target == d.FromGb {
+		d.Reason = "instance size matches policy"
+		return d
+	}
+
+	d.Action, d.ToGb = ActionResize, target
+	return d
+}
+
+// Evaluate fetches the instance, decides what to do for the given rate and applies it unless DryRun is set
+func (a *Autoscaler) Evaluate(ctx context.Context, rate float64) (Decision, error) {
+	name := fmt.Sprintf("%s/instances/%s", a.Parent, a.InstanceID)
+	instance, err := a.Admin.GetInstance(ctx, name)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return Decision{}, errs.Wrap(err, "failed to get instance")
+		}
+		instance, err = nil, nil
+	}
+
+	d := a.Decide(instance, rate, a.now())
+	if d.Action != ActionNone && !a.DryRun {
+		err = a.apply(ctx, name, instance, d)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Applied = true
+			a.mu.Lock()
+			a.last = d.Time
+			a.mu.Unlock()
+		}
+	}
+
+	log.Println("autoscaler decision:", d.Action, d.FromGb, "->", d.ToGb, d.Reason)
+	a.record(d)
+	return d, err
+}
+
+func (a *Autoscaler) apply(ctx context.Context, name string, instance *redispb.Instance, d Decision) error {
+	switch d.Action {
+	case ActionCreate:
+		template := &redispb.Instance{}
+		if a.Template != nil {
+			template = proto.Clone(a.Template).(*redispb.Instance)
+		}
+		template.Name = name
+		template.MemorySizeGb = d.ToGb
+		_, err := a.Admin.CreateInstance(ctx, a.Parent, a.InstanceID, template)
+		return err
+	case ActionResize:
+		_, err := a.Admin.UpdateInstance(ctx, &redispb.Instance{Name: instance.GetName(), MemorySizeGb: d.ToGb}, "memory_size_gb")
+		return err
+	case ActionDelete:
+		return a.Admin.DeleteInstance(ctx, name)
+	}
+	return nil
+}
+
+func (a *Autoscaler) record(d Decision) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.decisions = append(a.decisions, d)
+	if len(a.decisions) > maxDecisionHistory {
+		a.decisions = a.decisions[len(a.decisions)-maxDecisionHistory:]
+	}
+}
+
+// Decisions returns the most recent decisions, oldest first
+func (a *Autoscaler) Decisions() []Decision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]Decision, len(a.decisions))
+	copy(out, a.decisions)
+	return out
+}
+
+// Run evaluates the policy every interval using the rate measured by counter until ctx is cancelled
+func (a *Autoscaler) Run(ctx context.Context, interval time.Duration, counter *RequestCounter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.Evaluate(ctx, counter.Rate()); err != nil {
+				log.Println("autoscaler evaluation failed:", err)
+			}
+		}
+	}
+}
+
+// RequestCounter measures the request rate between calls to Rate
+type RequestCounter struct {
+	mu    sync.Mutex
+	count int64
+	since time.Time
+}
+
+func NewRequestCounter() *RequestCounter {
+	return &RequestCounter{since: time.Now()}
+}
+
+// Inc records a single request
+func (r *RequestCounter) Inc() {
+	r.mu.Lock()
+	r.count++
+	r.mu.Unlock()
+}
+
+// Rate returns requests per second since the previous call and resets the counter
+func (r *RequestCounter) Rate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(r.since).Seconds()
+	count := r.count
+	r.count, r.since = 0, now
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(count) / elapsed
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// in memory InstanceAdmin
+type fakeAdmin struct {
+	instances map[string]*redispb.Instance
+	calls     []string
+	auth      string
+}
+
+func newFakeAdmin() *fakeAdmin {
+	return &fakeAdmin{instances: map[string]*redispb.Instance{}}
+}
+
+func (f *fakeAdmin) GetInstance(ctx context.Context, name string) (*redispb.Instance, error) {
+	f.calls = append(f.calls, "get")
+	i, ok := f.instances[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return i, nil
+}
+
+func (f *fakeAdmin) ListInstances(ctx context.Context, parent string) ([]*redispb.Instance, error) {
+	f.calls = append(f.calls, "list")
+	var out []*redispb.Instance
+	for name, i := range f.instances {
+		if strings.HasPrefix(name, parent+"/") {
+			out = append(out, i)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (f *fakeAdmin) CreateInstance(ctx context.Context, parent string, id string, instance *redispb.Instance) (*redispb.Instance, error) {
+	f.calls = append(f.calls, "create")
+	instance.State = redispb.Instance_READY
+	f.instances[instance.Name] = instance
+	return instance, nil
+}
+
+func (f *fakeAdmin) UpdateInstance(ctx context.Context, instance *redispb.Instance, paths ...string) (*redispb.Instance, error) {
+	f.calls = append(f.calls, "update")
+	existing := f.instances[instance.Name]
+	for _, path := range paths {
+		switch path {
+		case "memory_size_gb":
+			existing.MemorySizeGb = instance.MemorySizeGb
+		case "auth_enabled":
+			existing.AuthEnabled = instance.AuthEnabled
+		case "maintenance_policy":
+			existing.MaintenancePolicy = instance.MaintenancePolicy
+		}
+	}
+	return existing, nil
+}
+
+func (f *fakeAdmin) DeleteInstance(ctx context.Context, name string) error {
+	f.calls = append(f.calls, "delete")
+	delete(f.instances, name)
+	return nil
+}
+
+func (f *fakeAdmin) GetAuthString(ctx context.Context, name string) (string, error) {
+	f.calls = append(f.calls, "auth")
+	return f.auth, nil
+}
+
+func TestScheduleWindowContains(t *testing.T) {
+	w := ScheduleWindow{Days: []time.Weekday{time.Monday}, Start: 9 * time.Hour, End: 17 * time.Hour}
+
+	var tests = []struct {
+		input    time.Time
+		expected bool
+	}{
+		{time.Date(2021, 1, 4, 9, 0, 0, 0, time.UTC), true},   // monday
+		{time.Date(2021, 1, 4, 16, 59, 0, 0, time.UTC), true}, // monday
+		{time.Date(2021, 1, 4, 17, 0, 0, 0, time.UTC), false}, // monday
+		{time.Date(2021, 1, 4, 8, 59, 0, 0, time.UTC), false}, // monday
+		{time.Date(2021, 1, 5, 12, 0, 0, 0, time.UTC), false}, // tuesday
+	}
+
+	for _, test := range tests {
+		if output := w.Contains(test.input); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	policy := ScalePolicy{
+		CreateRate:  100,
+		DeleteRate:  10,
+		GrowRate:    500,
+		ShrinkRate:  200,
+		MinMemoryGb: 1,
+		MaxMemoryGb: 3,
+		Windows:     []ScheduleWindow{{Start: 12 * time.Hour, End: 13 * time.Hour, MemoryGb: 2}},
+	}
+	a := NewAutoscaler(newFakeAdmin(), policy)
+
+	morning := time.Date(2021, 1, 4, 8, 0, 0, 0, time.UTC)
+	lunch := time.Date(2021, 1, 4, 12, 30, 0, 0, time.UTC)
+	ready := func(gb int32) *redispb.Instance {
+		return &redispb.Instance{MemorySizeGb: gb, State: redispb.Instance_READY}
+	}
+
+	tt := []struct {
+		name     string
+		instance *redispb.Instance
+		rate     float64
+		now      time.Time
+		action   Action
+		toGb     int32
+	}{
+		{"quiet no instance", nil, 5, morning, ActionNone, 0},
+		{"busy no instance", nil, 150, morning, ActionCreate, 1},
+		{"window no instance", nil, 0, lunch, ActionCreate, 2},
+		{"quiet instance", ready(1), 5, morning, ActionDelete, 0},
+		{"quiet instance in window", ready(2), 5, lunch, ActionNone, 2},
+		{"grow", ready(1), 600, morning, ActionResize, 2},
+		{"grow capped", ready(3), 600, morning, ActionNone, 3},
+		{"shrink", ready(3), 50, morning, ActionResize, 2},
+		{"shrink to window floor", ready(3), 50, lunch, ActionResize, 2},
+		{"steady", ready(2), 300, morning, ActionNone, 2},
+		{"creating", &redispb.Instance{State: redispb.Instance_CREATING}, 5, morning, ActionNone, 0},
+	}
+
+	for _, tc := range tt {
+		d := a.Decide(tc.instance, tc.rate, tc.now)
+		if d.Action != tc.action || d.ToGb != tc.toGb {
+			t.Errorf("%s failed; wanted: %v %dGB, got: %v %dGB (%s)", tc.name, tc.action, tc.toGb, d.Action, d.ToGb, d.Reason)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	admin := newFakeAdmin()
+	now := time.Date(2021, 1, 4, 8, 0, 0, 0, time.UTC)
+	a := NewAutoscaler(admin, ScalePolicy{CreateRate: 100, DeleteRate: 10, GrowRate: 500, ShrinkRate: 200, Cooldown: time.Minute})
+	a.Parent, a.InstanceID = "projects/p/locations/r", "cache"
+	a.now = func() time.Time { return now }
+
+	// dry run only records the decision
+	a.DryRun = true
+	if d, err := a.Evaluate(context.Background(), 150); err != nil || d.Action != ActionCreate || d.Applied {
+		t.Fatalf("dry run failed; got: %+v, err: %v", d, err)
+	}
+	if len(admin.instances) != 0 {
+		t.Fatalf("dry run created an instance")
+	}
+
+	a.DryRun = false
+	if d, err := a.Evaluate(context.Background(), 150); err != nil || !d.Applied {
+		t.Fatalf("create failed; got: %+v, err: %v", d, err)
+	}
+	if i := admin.instances["projects/p/locations/r/instances/cache"]; i == nil || i.MemorySizeGb != 1 {
+		t.Fatalf("instance not created with 1GB; got: %v", i)
+	}
+
+	// cool down prevents an immediate resize
+	if d, _ := a.Evaluate(context.Background(), 600); d.Action != ActionNone {
+		t.Fatalf("cool down ignored; got: %+v", d)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if d, _ := a.Evaluate(context.Background(), 600); d.Action != ActionResize || !d.Applied {
+		t.Fatalf("resize failed; got: %+v", d)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if d, _ := a.Evaluate(context.Background(), 0); d.Action != ActionDelete || !d.Applied {
+		t.Fatalf("delete failed; got: %+v", d)
+	}
+	if len(admin.instances) != 0 {
+		t.Fatalf("instance not deleted")
+	}
+
+	if l := len(a.Decisions()); l != 5 {
+		t.Errorf("wanted 5 recorded decisions, got: %d", l)
+	}
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	errs "github.com/pkg/errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// returned by the Memorystore admin functions when connecting directly to redis
+var ErrDirectMode = errors.New("memorystore admin unavailable when connecting directly to redis")
+
+var direct = DirectConfigFromEnv()
+
+// DirectConfig connects to redis without Memorystore discovery e.g. a local redis or emulator
+// Addrs are tried in order, Sentinels are asked for the address of SentinelMaster
+type DirectConfig struct {
+	Addrs          []string
+	Sentinels      []string
+	SentinelMaster string
+}
+
+// DirectConfigFromEnv reads REDIS_ADDR, REDIS_HOSTS (comma separated), REDIS_SENTINELS (comma separated) and REDIS_SENTINEL_MASTER
+// returns nil when none are set
+func DirectConfigFromEnv() *DirectConfig {
+	cfg := DirectConfig{
+		Addrs:          splitList(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_HOSTS")),
+		Sentinels:      splitList(os.Getenv("REDIS_SENTINELS")),
+		SentinelMaster: os.Getenv("REDIS_SENTINEL_MASTER"),
+	}
+	if len(cfg.Addrs) == 0 && len(cfg.Sentinels) == 0 {
+		return nil
+	}
+	return &cfg
+}
+
+// UseDirect switches the package to connect directly using cfg, pass nil to go back to Memorystore discovery
+func UseDirect(cfg *DirectConfig) {
+	direct = cfg
+	cacheFailed = true
+}
+
+// DirectMode reports whether the package skips Memorystore discovery
+func DirectMode() bool {
+	return direct != nil
+}
+
+// Resolve returns the first reachable redis address
+func (cfg DirectConfig) Resolve() (string, error) {
+	addrs := cfg.Addrs
+	if len(cfg.Sentinels) > 0 {
+		addr, err := cfg.sentinelMaster()
+		if err != nil {
+			return "", err
+		}
+		addrs = []string{addr}
+	}
+
+	for _, addr := range addrs {
+		c, err := redis.Dial("tcp", addr, redis.DialConnectTimeout(time.Millisecond*100))
+		if err != nil {
+			log.Println("redis not reachable:", addr, err)
+			continue
+		}
+
+		err = Ping(c)
+		c.Close()
+		if err == nil {
+			return addr, nil
+		}
+		log.Println("redis ping failed:", addr, err)
+	}
+	return "", errors.New("no redis address reachable")
+}
+
+// Pool returns the connection pool for addr returned by Resolve
+// with Sentinels every new connection asks for the current master and idle connections are checked to still
+// be the master when borrowed, so the pool follows a failover instead of dialling the old master
+func (cfg DirectConfig) Pool(addr string) *redis.Pool {
+	p := NewPool(addr)
+	if len(cfg.Sentinels) == 0 {
+		return p
+	}
+
+	p.Dial = func() (redis.Conn, error) {
+		master, err := cfg.sentinelMaster()
+		if err != nil {
+			return nil, err
+		}
+		return redis.Dial("tcp", master, redis.DialConnectTimeout(time.Millisecond*100))
+	}
+	p.TestOnBorrow = checkMasterRole
+	return p
+}
+
+// a demoted master reports the slave role, the pool then closes the connection and dials again
+func checkMasterRole(c redis.Conn, _ time.Time) error {
+	role, err := redis.Values(redis.DoWithTimeout(c, time.Millisecond*100, "ROLE"))
+	if err != nil {
+		return errs.Wrap(err, "failed to check redis role")
+	}
+	if len(role) == 0 {
+		return errors.New("empty redis role response")
+	}
+
+	name, err := redis.String(role[0], nil)
+	if err != nil {
+		return errs.Wrap(err, "failed to read redis role")
+	}
+	if name != "master" {
+		return fmt.Errorf("redis is a %s, not the master", name)
+	}
+	return nil
+}
+
+// ask each sentinel in turn for the current master address
+func (cfg DirectConfig) sentinelMaster() (string, error) {
+	if cfg.SentinelMaster == "" {
+		return "", errors.New("sentinel master name required")
+	}
+
+	var lastErr error
+	for _, s := range cfg.Sentinels {
+		c, err := redis.Dial("tcp", s, redis.DialConnectTimeout(time.Millisecond*100))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		res, err := redis.Strings(redis.DoWithTimeout(c, time.Millisecond*100, "SENTINEL", "get-master-addr-by-name", cfg.SentinelMaster))
+		c.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(res) != 2 {
+			lastErr = fmt.Errorf("unexpected sentinel response: %v", res)
+			continue
+		}
+		return fmt.Sprintf("%s:%s", res[0], res[1]), nil
+	}
+	return "", errs.Wrap(lastErr, "no sentinel returned a master")
+}
+
+func splitList(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
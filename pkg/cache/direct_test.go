@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDirectConfigFromEnv(t *testing.T) {
+	os.Setenv("REDIS_ADDR", "localhost:6379")
+	os.Setenv("REDIS_HOSTS", " 10.0.0.2:6379,,10.0.0.3:6379 ")
+	defer os.Unsetenv("REDIS_ADDR")
+	defer os.Unsetenv("REDIS_HOSTS")
+
+	cfg := DirectConfigFromEnv()
+	want := []string{"localhost:6379", "10.0.0.2:6379", "10.0.0.3:6379"}
+	if cfg == nil || !reflect.DeepEqual(cfg.Addrs, want) {
+		t.Fatalf("wanted: %v, got: %v", want, cfg)
+	}
+
+	os.Unsetenv("REDIS_ADDR")
+	os.Unsetenv("REDIS_HOSTS")
+	if cfg := DirectConfigFromEnv(); cfg != nil {
+		t.Fatalf("wanted nil config, got: %v", cfg)
+	}
+}
+
+func TestDirectModeDisablesAdmin(t *testing.T) {
+	UseDirect(&DirectConfig{Addrs: []string{"127.0.0.1:1"}})
+	defer UseDirect(nil)
+
+	if _, err := NewAdminClient(context.Background()); err != ErrDirectMode {
+		t.Fatalf("wanted ErrDirectMode, got: %v", err)
+	}
+
+	if err := CreateCacheInstance(); err != ErrDirectMode {
+		t.Fatalf("wanted ErrDirectMode, got: %v", err)
+	}
+
+	if _, err := direct.Resolve(); err == nil {
+		t.Fatalf("wanted unreachable address to fail")
+	}
+}
+
+func TestCheckMasterRole(t *testing.T) {
+	r := useFakeRedis(t)
+
+	var tests = []struct {
+		input string
+		valid bool
+	}{
+		{"", true},
+		{"slave", false},
+		{"sentinel", false},
+	}
+
+	for _, test := range tests {
+		r.role = test.input
+		conn := &fakeConn{r: r}
+		if err := checkMasterRole(conn, time.Now()); (err == nil) != test.valid {
+			t.Errorf("test failed; input: %v, wanted valid: %v, got: %v", test.input, test.valid, err)
+		}
+	}
+}
+
+func TestDirectPool(t *testing.T) {
+	if p := (DirectConfig{Addrs: []string{"127.0.0.1:6379"}}).Pool("127.0.0.1:6379"); p.TestOnBorrow != nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "addrs", "no role check", "role check")
+	}
+	if p := (DirectConfig{Sentinels: []string{"127.0.0.1:26379"}, SentinelMaster: "mymaster"}).Pool("10.0.0.2:6379"); p.TestOnBorrow == nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "sentinels", "role check", "none")
+	}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	"errors"
+	"fmt"
+	errs "github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrInstanceNotFound = errors.New("redis instance not found")
+	ErrInstanceNotReady = errors.New("redis instance not serving")
+)
+
+// Endpoint holds the connection details of a discovered instance
+type Endpoint struct {
+	Name     string
+	State    redispb.Instance_State
+	Tier     redispb.Instance_Tier
+	Host     string
+	Port     int32
+	ReadHost string // read replica endpoint, empty unless read replicas are enabled
+	ReadPort int32
+
+	AuthEnabled bool     // connections must AUTH with the instance's auth string
+	TLS         bool     // in-transit encryption is enabled
+	CACerts     []string // PEM certificates of the instance's server CA, set when TLS is enabled
+}
+
+// Addr returns the primary host:port
+func (e Endpoint) Addr() string {
+	return fmt.Sprintf("%s:%d", e.Host, e.Port)
+}
+
+// ReadAddr returns the host:port reads should be sent to
+// falls back to the primary when the instance has no read endpoint
+func (e Endpoint) ReadAddr() string {
+	if e.ReadHost == "" {
+		return e.Addr()
+	}
+	return fmt.Sprintf("%s:%d", e.ReadHost, e.ReadPort)
+}
+
+// Serving reports whether the instance accepts connections in its current state
+// an instance being scaled or under maintenance keeps serving from its existing host
+func Serving(instance *redispb.Instance) bool {
+	if instance.GetHost() == "" || instance.GetPort() == 0 {
+		return false
+	}
+
+	switch instance.GetState() {
+	case redispb.Instance_READY, redispb.Instance_UPDATING, redispb.Instance_MAINTENANCE:
+		return true
+	}
+	return false
+}
+
+// InstanceEndpoint returns the endpoint of a serving instance
+// the read endpoint is only set for STANDARD_HA instances with read replicas enabled
+func InstanceEndpoint(instance *redispb.Instance) (Endpoint, error) {
+	e := Endpoint{
+		Name:  instance.GetName(),
+		State: instance.GetState(),
+		Tier:  instance.GetTier(),
+		Host:  instance.GetHost(),
+		Port:  instance.GetPort(),
+
+		AuthEnabled: instance.GetAuthEnabled(),
+		TLS:         instance.GetTransitEncryptionMode() == redispb.Instance_SERVER_AUTHENTICATION,
+	}
+	if instance.GetReadReplicasMode() == redispb.Instance_READ_REPLICAS_ENABLED && instance.GetReadEndpoint() != "" {
+		e.ReadHost, e.ReadPort = instance.GetReadEndpoint(), instance.GetReadEndpointPort()
+	}
+	for _, cert := range instance.GetServerCaCerts() {
+		e.CACerts = append(e.CACerts, cert.GetCert())
+	}
+
+	if !Serving(instance) {
+		return e, errs.Wrap(ErrInstanceNotReady, instance.GetState().String())
+	}
+	return e, nil
+}
+
+// GetCacheInstance looks up an instance by its full resource name
+// e.g. projects/my-project/locations/europe-west2/instances/my-cache
+func GetCacheInstance(ctx context.Context, admin InstanceAdmin, name string) (*redispb.Instance, error) {
+	instance, err := admin.GetInstance(ctx, name)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errs.Wrap(ErrInstanceNotFound, name)
+		}
+		return nil, errs.Wrap(err, "failed to get instance")
+	}
+	return instance, nil
+}
+
+// ListCacheInstances returns every instance in parent which has all of the given labels
+func ListCacheInstances(ctx context.Context, admin InstanceAdmin, parent string, labels map[string]string) ([]*redispb.Instance, error) {
+	instances, err := admin.ListInstances(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*redispb.Instance
+	for _, instance := range instances {
+		if hasLabels(instance, labels) {
+			out = append(out, instance)
+		}
+	}
+	return out, nil
+}
+
+// FindCacheInstanceByDisplayName searches every instance in parent for a matching display name
+func FindCacheInstanceByDisplayName(ctx context.Context, admin InstanceAdmin, parent string, displayName string) (*redispb.Instance, error) {
+	instances, err := admin.ListInstances(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		if instance.GetDisplayName() == displayName {
+			return instance, nil
+		}
+	}
+	return nil, errs.Wrap(ErrInstanceNotFound, displayName)
+}
+
+func hasLabels(instance *redispb.Instance, labels map[string]string) bool {
+	for k, v := range labels {
+		if instance.GetLabels()[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDiscovery(t *testing.T) {
+	parent := "projects/p/locations/r"
+	admin := newFakeAdmin()
+	for _, i := range []*redispb.Instance{
+		{Name: parent + "/instances/a", DisplayName: "other", Labels: map[string]string{"team": "data"}},
+		{Name: parent + "/instances/b", DisplayName: "sessions", Labels: map[string]string{"team": "web"}},
+		{Name: parent + "/instances/c", DisplayName: "pages", Labels: map[string]string{"team": "web", "env": "prod"}},
+		{Name: "projects/p/locations/x/instances/d", Labels: map[string]string{"team": "web"}},
+	} {
+		admin.instances[i.Name] = i
+	}
+	ctx := context.Background()
+
+	web, err := ListCacheInstances(ctx, admin, parent, map[string]string{"team": "web"})
+	if err != nil || len(web) != 2 {
+		t.Fatalf("wanted 2 web instances, got: %v, err: %v", web, err)
+	}
+
+	all, err := ListCacheInstances(ctx, admin, parent, nil)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("wanted 3 instances, got: %v, err: %v", all, err)
+	}
+
+	// not the first instance in the location
+	found, err := FindCacheInstanceByDisplayName(ctx, admin, parent, "pages")
+	if err != nil || found.GetName() != parent+"/instances/c" {
+		t.Fatalf("display name lookup failed; got: %v, err: %v", found, err)
+	}
+
+	if _, err := GetCacheInstance(ctx, admin, parent+"/instances/missing"); !errors.Is(err, ErrInstanceNotFound) {
+		t.Fatalf("wanted not found, got: %v", err)
+	}
+}
+
+func TestInstanceEndpoint(t *testing.T) {
+	var tests = []struct {
+		input    redispb.Instance_State
+		expected bool
+	}{
+		{redispb.Instance_CREATING, false},
+		{redispb.Instance_READY, true},
+		{redispb.Instance_UPDATING, true},
+		{redispb.Instance_MAINTENANCE, true},
+		{redispb.Instance_DELETING, false},
+	}
+
+	for _, test := range tests {
+		e, err := InstanceEndpoint(&redispb.Instance{Host: "10.0.0.3", Port: 6379, State: test.input})
+		if output := err == nil; output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+		if e.Addr() != "10.0.0.3:6379" || e.ReadAddr() != "10.0.0.3:6379" {
+			t.Errorf("unexpected address; got: %s, %s", e.Addr(), e.ReadAddr())
+		}
+	}
+}
+
+func TestInstanceEndpointSecurity(t *testing.T) {
+	e, err := InstanceEndpoint(&redispb.Instance{
+		Host:                  "10.0.0.3",
+		Port:                  6378,
+		State:                 redispb.Instance_READY,
+		AuthEnabled:           true,
+		TransitEncryptionMode: redispb.Instance_SERVER_AUTHENTICATION,
+		ServerCaCerts:         []*redispb.TlsCertificate{{Cert: "cert"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.AuthEnabled || !e.TLS || len(e.CACerts) != 1 || e.CACerts[0] != "cert" {
+		t.Errorf("test failed; wanted auth and tls, got: %+v", e)
+	}
+
+	// the ca certificates must be valid PEM to connect
+	if _, err := NewEndpointPool(e, "secret"); err == nil {
+		t.Error("test failed; wanted invalid certificate error")
+	}
+	e.CACerts = nil
+	if _, err := NewEndpointPool(e, "secret"); err != nil {
+		t.Errorf("test failed; wanted pool, got: %v", err)
+	}
+}
+
+func TestInstanceEndpointReadReplicas(t *testing.T) {
+	var tests = []struct {
+		input    *redispb.Instance
+		expected string
+	}{
+		{&redispb.Instance{Host: "10.0.0.3", Port: 6379, State: redispb.Instance_READY}, "10.0.0.3:6379"},
+		{&redispb.Instance{
+			Host: "10.0.0.3", Port: 6379, State: redispb.Instance_READY,
+			ReadReplicasMode: redispb.Instance_READ_REPLICAS_ENABLED, ReadEndpoint: "10.0.0.4", ReadEndpointPort: 6379,
+		}, "10.0.0.4:6379"},
+		{&redispb.Instance{
+			Host: "10.0.0.3", Port: 6379, State: redispb.Instance_READY,
+			ReadReplicasMode: redispb.Instance_READ_REPLICAS_DISABLED, ReadEndpoint: "10.0.0.4", ReadEndpointPort: 6379,
+		}, "10.0.0.3:6379"},
+	}
+
+	for _, test := range tests {
+		e, err := InstanceEndpoint(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := e.ReadAddr(); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input.GetReadReplicasMode(), test.expected, output)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in memory redis supporting the commands used by this package
+// fail makes a command return an error, inside MULTI the error is returned in the EXEC results
+type fakeRedis struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	hashes map[string]map[string]string
+	ttls   map[string]int64
+	fail   map[string]error
+	role   string // returned by ROLE, defaults to master
+}
+
+// useFakeRedis points the package pool at a new fakeRedis until the test ends
+func useFakeRedis(t *testing.T) *fakeRedis {
+	f := &fakeRedis{
+		values: map[string][]byte{},
+		hashes: map[string]map[string]string{},
+		ttls:   map[string]int64{},
+		fail:   map[string]error{},
+	}
+
+	oldPool, oldFailed := pool, cacheFailed
+	pool = &redis.Pool{Dial: func() (redis.Conn, error) {
+		return &fakeConn{r: f}, nil
+	}}
+	cacheFailed = false
+	t.Cleanup(func() {
+		pool, cacheFailed = oldPool, oldFailed
+	})
+	return f
+}
+
+func (f *fakeRedis) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for k := range f.values {
+		keys = append(keys, k)
+	}
+	for k := range f.hashes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeRedis) do(cmd string, args ...interface{}) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cmd = strings.ToUpper(cmd)
+	if err := f.fail[cmd]; err != nil {
+		return nil, err
+	}
+
+	s := make([]string, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case []byte:
+			s[i] = string(v)
+		case string:
+			s[i] = v
+		default:
+			s[i] = strconv.FormatInt(toInt64(v), 10)
+		}
+	}
+
+	switch cmd {
+	case "", "PING", "DISCARD":
+		return "OK", nil
+	case "ROLE":
+		if f.role == "" {
+			return []interface{}{[]byte("master"), int64(0), []interface{}{}}, nil
+		}
+		return []interface{}{[]byte(f.role)}, nil
+	case "GET":
+		if v, ok := f.values[s[0]]; ok {
+			return v, nil
+		}
+		return nil, nil
+	case "SET":
+		if _, exists := f.values[s[0]]; exists && contains(s[2:], "NX") {
+			return nil, nil
+		}
+		f.values[s[0]] = []byte(s[1])
+		delete(f.ttls, s[0])
+		for i := 2; i < len(s)-1; i++ {
+			if s[i] == "PX" {
+				f.ttls[s[0]], _ = strconv.ParseInt(s[i+1], 10, 64)
+			}
+		}
+		return "OK", nil
+	case "INCR":
+		n, _ := strconv.ParseInt(string(f.values[s[0]]), 10, 64)
+		n++
+		f.values[s[0]] = []byte(strconv.FormatInt(n, 10))
+		return n, nil
+	case "DEL", "UNLINK":
+		var n int64
+		for _, k := range s {
+			_, isValue := f.values[k]
+			_, isHash := f.hashes[k]
+			if isValue || isHash {
+				n++
+			}
+			delete(f.values, k)
+			delete(f.hashes, k)
+			delete(f.ttls, k)
+		}
+		return n, nil
+	case "HSET":
+		h, ok := f.hashes[s[0]]
+		if !ok {
+			h = map[string]string{}
+			f.hashes[s[0]] = h
+		}
+		for i := 1; i < len(s)-1; i += 2 {
+			h[s[i]] = s[i+1]
+		}
+		return int64((len(s) - 1) / 2), nil
+	case "HGETALL":
+		var reply []interface{}
+		for k, v := range f.hashes[s[0]] {
+			reply = append(reply, []byte(k), []byte(v))
+		}
+		return reply, nil
+	case "PEXPIRE":
+		_, isValue := f.values[s[0]]
+		_, isHash := f.hashes[s[0]]
+		if !isValue && !isHash {
+			return int64(0), nil
+		}
+		f.ttls[s[0]], _ = strconv.ParseInt(s[1], 10, 64)
+		return int64(1), nil
+	case "SCAN":
+		pattern := "*"
+		for i := 1; i < len(s)-1; i++ {
+			if s[i] == "MATCH" {
+				pattern = s[i+1]
+			}
+		}
+		var keys []interface{}
+		for k := range f.values {
+			if ok, _ := path.Match(pattern, k); ok {
+				keys = append(keys, []byte(k))
+			}
+		}
+		for k := range f.hashes {
+			if ok, _ := path.Match(pattern, k); ok {
+				keys = append(keys, []byte(k))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	}
+	return nil, errors.New("fake redis: unsupported command " + cmd)
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	}
+	return 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeConn queues sent commands until the next Do, running MULTI blocks on EXEC
+type fakeConn struct {
+	r       *fakeRedis
+	pending [][]interface{}
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Err() error {
+	return nil
+}
+
+func (c *fakeConn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (c *fakeConn) Flush() error {
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) {
+	return nil, errors.New("fake redis: receive isn't supported")
+}
+
+func (c *fakeConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+func (c *fakeConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	pending := c.pending
+	c.pending = nil
+
+	var queued [][]interface{}
+	multi := false
+	for _, p := range pending {
+		name := strings.ToUpper(p[0].(string))
+		switch {
+		case name == "MULTI":
+			multi = true
+		case multi:
+			queued = append(queued, p)
+		default:
+			c.r.do(name, p[1:]...)
+		}
+	}
+
+	if strings.ToUpper(cmd) != "EXEC" {
+		return c.r.do(cmd, args...)
+	}
+
+	c.r.mu.Lock()
+	err := c.r.fail["EXEC"]
+	c.r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// per command errors are returned in the results like a real EXEC
+	var results []interface{}
+	for _, q := range queued {
+		reply, err := c.r.do(q[0].(string), q[1:]...)
+		if err != nil {
+			results = append(results, redis.Error(err.Error()))
+			continue
+		}
+		results = append(results, reply)
+	}
+	return results, nil
+}
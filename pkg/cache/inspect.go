@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	errs "github.com/pkg/errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const defaultDeleteBatch = 100
+
+// KeyspaceStats summarises the keys matching a pattern
+type KeyspaceStats struct {
+	Keys        int            `json:"keys"`
+	MemoryBytes int64          `json:"memoryBytes"`
+	Types       map[string]int `json:"types"`
+	TTLs        map[string]int `json:"ttls"` // count of keys per TTL bucket
+}
+
+// exported key, Value is the output of DUMP
+type keyDump struct {
+	Key   string `json:"key"`
+	TTLMs int64  `json:"ttlMs"`
+	Value []byte `json:"value"`
+}
+
+type DeleteOptions struct {
+	BatchSize int           // keys unlinked per command, defaults to 100
+	Pause     time.Duration // sleep between batches to limit load on a live instance
+	DryRun    bool          // count matching keys without deleting them
+	AllowAll  bool          // required to delete with a pattern without a literal prefix e.g. * or *:*
+}
+
+// ScanKeys returns up to limit keys matching pattern, 0 means no limit
+func ScanKeys(pattern string, limit int) ([]string, error) {
+	var out []string
+	stop := errors.New("limit reached")
+
+	err := scanKeys(pattern, func(keys []string) error {
+		for _, k := range keys {
+			if limit > 0 && len(out) >= limit {
+				return stop
+			}
+			out = append(out, k)
+		}
+		return nil
+	})
+	if err == stop {
+		err = nil
+	}
+	return out, err
+}
+
+// Stats reports the memory usage, types and TTL distribution of the keys matching pattern
+func Stats(pattern string) (KeyspaceStats, error) {
+	stats := KeyspaceStats{Types: map[string]int{}, TTLs: map[string]int{}}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	err := scanKeys(pattern, func(keys []string) error {
+		for _, k := range keys {
+			conn.Send("MEMORY", "USAGE", k)
+			conn.Send("TYPE", k)
+			conn.Send("PTTL", k)
+		}
+		if err := conn.Flush(); err != nil {
+			return errs.Wrap(err, "failed to flush stats batch")
+		}
+
+		for range keys {
+			mem, err := redis.Int64(conn.Receive())
+			if err != nil && err != redis.ErrNil {
+				return errs.Wrap(err, "failed to get memory usage")
+			}
+			typ, err := redis.String(conn.Receive())
+			if err != nil {
+				return errs.Wrap(err, "failed to get type")
+			}
+			ttl, err := redis.Int64(conn.Receive())
+			if err != nil {
+				return errs.Wrap(err, "failed to get ttl")
+			}
+
+			// key expired between SCAN and the lookups
+			if typ == "none" {
+				continue
+			}
+
+			stats.Keys++
+			stats.MemoryBytes += mem
+			stats.Types[typ]++
+			stats.TTLs[ttlBucket(time.Duration(ttl)*time.Millisecond)]++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func ttlBucket(ttl time.Duration) string {
+	switch {
+	case ttl < 0:
+		return "none"
+	case ttl < time.Minute:
+		return "<1m"
+	case ttl < time.Hour:
+		return "<1h"
+	case ttl < 24*time.Hour:
+		return "<1d"
+	}
+	return ">=1d"
+}
+
+// ExportKeys writes the keys matching pattern to w as JSON lines using DUMP, returning the number exported
+func ExportKeys(pattern string, w io.Writer) (int, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	enc := json.NewEncoder(w)
+	exported := 0
+	err := scanKeys(pattern, func(keys []string) error {
+		for _, k := range keys {
+			conn.Send("PTTL", k)
+			conn.Send("DUMP", k)
+		}
+		if err := conn.Flush(); err != nil {
+			return errs.Wrap(err, "failed to flush export batch")
+		}
+
+		for _, k := range keys {
+			ttl, err := redis.Int64(conn.Receive())
+			if err != nil {
+				return errs.Wrap(err, "failed to get ttl")
+			}
+			value, err := redis.Bytes(conn.Receive())
+			if err == redis.ErrNil {
+				continue // expired
+			}
+			if err != nil {
+				return errs.Wrap(err, "failed to dump key")
+			}
+
+			if err := enc.Encode(keyDump{Key: k, TTLMs: ttl, Value: value}); err != nil {
+				return errs.Wrap(err, "failed to write key")
+			}
+			exported++
+		}
+		return nil
+	})
+	return exported, err
+}
+
+// ImportKeys restores keys written by ExportKeys, existing keys are only overwritten when replace is set
+func ImportKeys(r io.Reader, replace bool) (int, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+
+	imported := 0
+	for scanner.Scan() {
+		var d keyDump
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return imported, errs.Wrap(err, "failed to read key")
+		}
+
+		ttl := d.TTLMs
+		if ttl < 0 {
+			ttl = 0
+		}
+		args := []interface{}{d.Key, ttl, d.Value}
+		if replace {
+			args = append(args, "REPLACE")
+		}
+
+		if _, err := redis.DoWithTimeout(conn, time.Second, "RESTORE", args...); err != nil {
+			return imported, errs.Wrap(err, "failed to restore "+d.Key)
+		}
+		imported++
+	}
+	return imported, scanner.Err()
+}
+
+// DeleteKeys unlinks the keys matching pattern in batches, returning the number deleted (or matched on a dry run)
+func DeleteKeys(pattern string, opts DeleteOptions) (int, error) {
+	if pattern == "" || (!hasLiteralPrefix(pattern) && !opts.AllowAll) {
+		return 0, errors.New("refusing to delete keys without a literal prefix in the pattern without AllowAll")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultDeleteBatch
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	deleted := 0
+	err := scanKeys(pattern, func(keys []string) error {
+		if opts.DryRun {
+			deleted += len(keys)
+			return nil
+		}
+
+		for start := 0; start < len(keys); start += opts.BatchSize {
+			end := start + opts.BatchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+
+			args := make([]interface{}, 0, end-start)
+			for _, k := range keys[start:end] {
+				args = append(args, k)
+			}
+			n, err := redis.Int(redis.DoWithTimeout(conn, time.Second, "UNLINK", args...))
+			if err != nil {
+				return errs.Wrap(err, "failed to unlink keys")
+			}
+			deleted += n
+
+			if opts.Pause > 0 {
+				time.Sleep(opts.Pause)
+			}
+		}
+		return nil
+	})
+
+	log.Println("deleted", deleted, "keys matching", pattern, "dry run:", opts.DryRun)
+	return deleted, err
+}
+
+// hasLiteralPrefix reports whether pattern starts with something other than wildcards and separators
+// e.g. orders:* does, *, *:* and ?* match nearly every key
+func hasLiteralPrefix(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return false
+		case ':', '.', '-', '_', '/', ' ':
+			continue
+		case '\\':
+			i++
+			if i < len(pattern) && !strings.ContainsRune(":.-_/ ", rune(pattern[i])) {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
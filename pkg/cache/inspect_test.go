@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLBucket(t *testing.T) {
+	var tests = []struct {
+		input    time.Duration
+		expected string
+	}{
+		{-time.Millisecond, "none"},
+		{30 * time.Second, "<1m"},
+		{time.Minute, "<1h"},
+		{2 * time.Hour, "<1d"},
+		{48 * time.Hour, ">=1d"},
+	}
+
+	for _, test := range tests {
+		if output := ttlBucket(test.input); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestDeleteKeysRefusesEverything(t *testing.T) {
+	for _, p := range []string{"", "*", "**", "*:*", "?*", ":*", "[a-z]*"} {
+		if _, err := DeleteKeys(p, DeleteOptions{}); err == nil {
+			t.Errorf("expected pattern %q to be refused", p)
+		}
+	}
+}
+
+func TestHasLiteralPrefix(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected bool
+	}{
+		{"orders:*", true},
+		{"o*", true},
+		{"orders", true},
+		{"*", false},
+		{"*:*", false},
+		{"?*", false},
+		{"::*", false},
+		{"[o]rders:*", false},
+		{"\\*orders", true},
+		{"\\:*", false},
+	}
+
+	for _, test := range tests {
+		if output := hasLiteralPrefix(test.input); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
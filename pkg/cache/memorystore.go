@@ -1,16 +1,14 @@
 package cache
 
 import (
-	redisman "cloud.google.com/go/redis/apiv1beta1"
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
 	"context"
 	"errors"
-	"fmt"
+	"github.com/gomodule/redigo/redis"
 	"github.com/mousybusiness/googlecloudgo/pkg/bq"
 	errs "github.com/pkg/errors"
-	redispb "google.golang.org/genproto/googleapis/cloud/redis/v1beta1"
 	"log"
 	"os"
-	"strings"
 )
 
 var (
@@ -20,37 +18,21 @@ var (
 	instanceId   = os.Getenv("MEMORYSTORE_INSTANCE")
 )
 
-// creates the Memorystore Redis instance
+// creates the Memorystore Redis instance using the environment configuration
+// use EnsureInstance to provision from an InstanceSpec
 func CreateCacheInstance() error {
 	ctx := context.Background()
-	client, err := redisman.NewCloudRedisClient(ctx)
+	admin, err := NewAdminClient(ctx)
 	if err != nil {
 		return err
 	}
 
-	template := redispb.Instance{
-		Name:         fmt.Sprintf("projects/%s/locations/%s/instances/%s", projectId, region, instanceId),
-		DisplayName:  instanceId,
-		RedisVersion: redisVersion,
-		Tier:         redispb.Instance_BASIC,
-		MemorySizeGb: 1,
-	}
-
-	parent := fmt.Sprintf("projects/%s/locations/%s", projectId, region)
-	log.Println("redis instance parent")
-
-	cio, err := client.CreateInstance(ctx, &redispb.CreateInstanceRequest{
-		Parent:     parent,
-		InstanceId: instanceId,
-		Instance:   &template,
-	})
-	if err != nil {
-		return errs.Wrap(err, "error while trying to create cache")
-	}
+	spec := SpecFromEnv()
+	log.Println("redis instance parent", spec.Parent())
 
-	instance, err := cio.Wait(ctx)
+	instance, err := admin.CreateInstance(ctx, spec.Parent(), spec.InstanceID, spec.Instance())
 	if err != nil {
-		return errs.Wrap(err, "error while waiting for create")
+		return err
 	}
 
 	log.Println("REDIS", instance.GetHost())
@@ -61,93 +43,71 @@ func CreateCacheInstance() error {
 // deletes the Memorystore Redis instance
 func DeleteCacheInstance() error {
 	ctx := context.Background()
-	client, err := redisman.NewCloudRedisClient(ctx)
+	admin, err := NewAdminClient(ctx)
 	if err != nil {
 		return err
 	}
 
-	name := fmt.Sprintf("projects/%s/locations/%s/instances/%s", projectId, region, instanceId)
+	name := SpecFromEnv().Name()
 	log.Println("instance name", name)
-	dio, err := client.DeleteInstance(ctx, &redispb.DeleteInstanceRequest{
-		Name: name,
-	})
-
-	if err != nil {
-		return errs.Wrap(err, "error while trying to delete cache")
-	}
-
-	if err := dio.Wait(ctx); err != nil {
-		return errs.Wrap(err, "error while waiting for delete")
-	}
-
-	return nil
+	return admin.DeleteInstance(ctx, name)
 }
 
 // using redis sdk try and find instance details i.e. host ip and port (instance might not exist!)
+// MEMORYSTORE_INSTANCE is looked up as the instance id, falling back to a display name search
 func FindCacheInstance() (*redispb.Instance, error) {
 	ctx := context.Background()
-	client, err := redisman.NewCloudRedisClient(ctx)
+	admin, err := NewAdminClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	instances := client.ListInstances(ctx, &redispb.ListInstancesRequest{
-		Parent:   fmt.Sprintf("projects/%s/locations/%s", projectId, region),
-		PageSize: 1,
-	})
-
-	if instances == nil {
-		return nil, errors.New("instances is nil")
+	spec := SpecFromEnv()
+	instance, err := GetCacheInstance(ctx, admin, spec.Name())
+	if errors.Is(err, ErrInstanceNotFound) {
+		log.Println("instance id not found, searching display names:", instanceId)
+		instance, err = FindCacheInstanceByDisplayName(ctx, admin, spec.Parent(), instanceId)
 	}
-
-	next, err := instances.Next()
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println(next)
+	log.Println("found redis instance", instance.GetName(), instance.GetState())
+	return instance, nil
+}
 
-	if next.GetDisplayName() != instanceId {
-		log.Println("instance id doesnt match required, received:", next.GetDisplayName(), "wanted:", instanceId)
-		return nil, errors.New("instance id doesnt match required")
-	}
+// creates the pool for a discovered instance, fetching the auth string when AUTH is enabled
+func memorystorePool(endpoint Endpoint) (*redis.Pool, error) {
+	var password string
+	if endpoint.AuthEnabled {
+		ctx := context.Background()
+		admin, err := NewAdminClient(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	return next, nil
+		password, err = admin.GetAuthString(ctx, endpoint.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewEndpointPool(endpoint, password)
 }
 
 // query BigQuery for all rows in table and put into redis (preloading the cache)
 // keyColumn is the column number which you want to use for your redis key for each row
+// use Preload for other sources and key functions
 func PreloadCache(keyColumn int) error {
 	ValidateMemoryStoreAndCreatePool() // duplicated from client - cant find a good way to unify without creating an external helper
 
-	rows, err := bq.QueryBQ(bq.BuildQuery(bq.DefaultFrom(), nil, ""))
+	src, err := BQSource(bq.BuildQuery(bq.DefaultFrom(), nil, ""))
 	if err != nil {
-		return errs.Wrap(err, "error while querying BQ")
+		return err
 	}
 
-	if err := putInCache(keyColumn, rows); err != nil {
+	if _, err := Preload(context.Background(), src, PreloadOptions{Key: ColumnKey(keyColumn), Encode: EncodeCSV}); err != nil {
 		return errs.Wrap(err, "Putting in cache failed")
 	}
 
 	return nil
 }
-
-// load redis cache with all the rows received from BQ
-func putInCache(keyColumn int, rows []string) error {
-	log.Println("executing putInCache")
-
-	if cacheFailed {
-		log.Println("previous cache attempts have failed")
-		return errors.New("cache isn't reachable")
-	}
-
-	// could be improved in speed by using MSET
-	// could be improved by using gob encoding
-	for _, v := range rows {
-		if err := Set(strings.Split(v, ",")[keyColumn], []byte(v)); err != nil {
-			return errors.New("FAILED TO SET TO REDIS")
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	errs "github.com/pkg/errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scanCount           = 1000
+	generationRefresh   = 10 * time.Second
+	generationKeyFormat = "%s:generation"
+)
+
+// Namespace isolates the keys of a service sharing the redis instance
+// keys are written as service:v{Version}:g{generation}:key where Version is the schema version of the
+// stored values and the generation is a counter kept in redis which Invalidate bumps
+type Namespace struct {
+	Service string
+	Version int
+	TTL     time.Duration // applied by Set when > 0
+
+	mu        sync.Mutex
+	gen       int64
+	refreshed time.Time
+}
+
+// ErrInvalidNamespace is returned for services which are empty or contain ":" or glob characters
+// otherwise the keys of orders would include those of a service named orders:eu
+var ErrInvalidNamespace = errors.New("namespace service must be non empty without : or glob characters")
+
+// NewNamespace panics when service isn't valid, see ErrInvalidNamespace
+func NewNamespace(service string, version int) *Namespace {
+	if err := validService(service); err != nil {
+		panic(errs.Wrap(err, "cache.NewNamespace "+service).Error())
+	}
+	return &Namespace{Service: service, Version: version}
+}
+
+// Key returns the full redis key for key in the current generation
+func (n *Namespace) Key(key string) (string, error) {
+	prefix, err := n.Prefix()
+	if err != nil {
+		return "", err
+	}
+	return prefix + key, nil
+}
+
+// Prefix returns the prefix of every key in the current schema version and generation
+func (n *Namespace) Prefix() (string, error) {
+	if err := validService(n.Service); err != nil {
+		return "", err
+	}
+	gen, err := n.generation()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:v%d:g%d:", n.Service, n.Version, gen), nil
+}
+
+func (n *Namespace) Get(key string) ([]byte, error) {
+	k, err := n.Key(key)
+	if err != nil {
+		return nil, err
+	}
+	return Get(k)
+}
+
+func (n *Namespace) Set(key string, value []byte) error {
+	k, err := n.Key(key)
+	if err != nil {
+		return err
+	}
+	if n.TTL > 0 {
+		return SetWithTTL(k, value, n.TTL)
+	}
+	return Set(k, value)
+}
+
+func (n *Namespace) Delete(key string) error {
+	k, err := n.Key(key)
+	if err != nil {
+		return err
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err = redis.DoWithTimeout(conn, time.Millisecond*100, "UNLINK", k)
+	return err
+}
+
+// Invalidate makes every existing key in the namespace unreachable by bumping the generation
+// old keys are left to expire or be removed by Purge
+func (n *Namespace) Invalidate() error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	gen, err := redis.Int64(redis.DoWithTimeout(conn, time.Millisecond*100, "INCR", n.generationKey()))
+	if err != nil {
+		return errs.Wrap(err, "failed to bump namespace generation")
+	}
+
+	n.mu.Lock()
+	n.gen, n.refreshed = gen, time.Now()
+	n.mu.Unlock()
+
+	log.Println("invalidated namespace", n.Service, "now generation", gen)
+	return nil
+}
+
+// Purge unlinks every key of the service outside of the current schema version and generation
+func (n *Namespace) Purge() (int, error) {
+	prefix, err := n.Prefix()
+	if err != nil {
+		return 0, err
+	}
+
+	genKey := n.generationKey()
+	return unlinkMatching(n.pattern(), func(key string) bool {
+		return key != genKey && !strings.HasPrefix(key, prefix)
+	})
+}
+
+// Flush unlinks every key of the service including the current generation
+func (n *Namespace) Flush() (int, error) {
+	if err := validService(n.Service); err != nil {
+		return 0, err
+	}
+	genKey := n.generationKey()
+	return unlinkMatching(n.pattern(), func(key string) bool {
+		return key != genKey
+	})
+}
+
+// KeyCounts returns the number of keys under each version and generation prefix of the service
+func (n *Namespace) KeyCounts() (map[string]int, error) {
+	if err := validService(n.Service); err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	genKey := n.generationKey()
+
+	err := scanKeys(n.pattern(), func(keys []string) error {
+		for _, k := range keys {
+			if k == genKey {
+				continue
+			}
+			counts[namespacePrefix(k)]++
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// pattern matches every key of the service, escaped in case of a Namespace built without NewNamespace
+func (n *Namespace) pattern() string {
+	return escapePattern(n.Service) + ":*"
+}
+
+func validService(service string) error {
+	if service == "" || strings.ContainsAny(service, `:*?[]\`) {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+func (n *Namespace) generationKey() string {
+	return fmt.Sprintf(generationKeyFormat, n.Service)
+}
+
+// generation is cached for a short period so other instances pick up an Invalidate without a GET per call
+func (n *Namespace) generation() (int64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.refreshed.IsZero() && time.Since(n.refreshed) < generationRefresh {
+		return n.gen, nil
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	gen, err := redis.Int64(redis.DoWithTimeout(conn, time.Millisecond*100, "GET", n.generationKey()))
+	if err != nil && err != redis.ErrNil {
+		return 0, errs.Wrap(err, "failed to get namespace generation")
+	}
+
+	n.gen, n.refreshed = gen, time.Now()
+	return gen, nil
+}
+
+var patternEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// escapePattern escapes the glob characters of SCAN MATCH in s
+func escapePattern(s string) string {
+	return patternEscaper.Replace(s)
+}
+
+// service:v1:g2:key -> service:v1:g2:
+func namespacePrefix(key string) string {
+	parts := strings.SplitN(key, ":", 4)
+	if len(parts) < 4 {
+		return key
+	}
+	return strings.Join(parts[:3], ":") + ":"
+}
+
+// scanKeys iterates every key matching pattern using SCAN, calling fn with each batch
+func scanKeys(pattern string, fn func(keys []string) error) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	cursor := 0
+	for {
+		values, err := redis.Values(redis.DoWithTimeout(conn, time.Second, "SCAN", cursor, "MATCH", pattern, "COUNT", scanCount))
+		if err != nil {
+			return errs.Wrap(err, "failed to scan keys")
+		}
+
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return errs.Wrap(err, "failed to read scan response")
+		}
+
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// unlinkMatching unlinks the keys matching pattern for which remove returns true, batch by batch
+func unlinkMatching(pattern string, remove func(key string) bool) (int, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	removed := 0
+	err := scanKeys(pattern, func(keys []string) error {
+		var args []interface{}
+		for _, k := range keys {
+			if remove(k) {
+				args = append(args, k)
+			}
+		}
+		if len(args) == 0 {
+			return nil
+		}
+
+		n, err := redis.Int(redis.DoWithTimeout(conn, time.Second, "UNLINK", args...))
+		if err != nil {
+			return errs.Wrap(err, "failed to unlink keys")
+		}
+		removed += n
+		return nil
+	})
+	return removed, err
+}
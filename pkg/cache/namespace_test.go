@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"reflect"
+	"testing"
+)
+
+func TestNamespacePrefix(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"orders:v1:g0:123", "orders:v1:g0:"},
+		{"orders:v2:g14:a:b:c", "orders:v2:g14:"},
+		{"orders:legacy", "orders:legacy"},
+	}
+
+	for _, test := range tests {
+		if output := namespacePrefix(test.input); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestEscapePattern(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"orders", "orders"},
+		{"ord*", `ord\*`},
+		{"a?[b]", `a\?\[b\]`},
+		{`a\b`, `a\\b`},
+	}
+
+	for _, test := range tests {
+		if output := escapePattern(test.input); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestNamespaceInvalidate(t *testing.T) {
+	useFakeRedis(t)
+	n := NewNamespace("orders", 1)
+
+	if err := n.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := n.Key("a")
+	if err := n.Invalidate(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := n.Key("a")
+
+	if before != "orders:v1:g0:a" || after != "orders:v1:g1:a" {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "invalidate", "orders:v1:g0:a orders:v1:g1:a", before+" "+after)
+	}
+	if _, err := n.Get("a"); err == nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "get after invalidate", redis.ErrNil, err)
+	}
+
+	// other instances pick up the new generation once their cached one is refreshed
+	other := NewNamespace("orders", 1)
+	if k, _ := other.Key("a"); k != after {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "other instance", after, k)
+	}
+}
+
+func TestValidService(t *testing.T) {
+	var tests = []struct {
+		input string
+		valid bool
+	}{
+		{"orders", true},
+		{"orders-eu", true},
+		{"", false},
+		{"orders:eu", false},
+		{"ord*", false},
+		{"ord?", false},
+		{"[o]rders", false},
+		{`ord\ers`, false},
+	}
+
+	for _, test := range tests {
+		if err := validService(test.input); (err == nil) != test.valid {
+			t.Errorf("test failed; input: %v, wanted valid: %v, got: %v", test.input, test.valid, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", "NewNamespace(orders:eu)", "panic", nil)
+		}
+	}()
+	NewNamespace("orders:eu", 1)
+}
+
+func TestNamespacePurge(t *testing.T) {
+	r := useFakeRedis(t)
+	for _, k := range []string{"orders:v1:g0:a", "orders:v1:g1:b", "orders:v0:g1:c", "orders-eu:v1:g0:a", "orders-eu:v1:g1:b", "ordersx:v1:g0:a"} {
+		r.values[k] = []byte("1")
+	}
+	r.values["orders:generation"] = []byte("1")
+	r.values["orders-eu:generation"] = []byte("1")
+
+	orders := NewNamespace("orders", 1)
+	ordersEU := NewNamespace("orders-eu", 1)
+
+	if counts, err := orders.KeyCounts(); err != nil || !reflect.DeepEqual(counts, map[string]int{"orders:v1:g0:": 1, "orders:v1:g1:": 1, "orders:v0:g1:": 1}) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "key counts", "orders keys only", counts, err)
+	}
+
+	if removed, err := orders.Purge(); err != nil || removed != 2 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "purge", 2, removed, err)
+	}
+	expected := []string{"orders-eu:generation", "orders-eu:v1:g0:a", "orders-eu:v1:g1:b", "orders:generation", "orders:v1:g1:b", "ordersx:v1:g0:a"}
+	if keys := r.keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "purge", expected, keys)
+	}
+
+	if removed, err := ordersEU.Flush(); err != nil || removed != 2 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "flush", 2, removed, err)
+	}
+	expected = []string{"orders-eu:generation", "orders:generation", "orders:v1:g1:b", "ordersx:v1:g0:a"}
+	if keys := r.keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "flush", expected, keys)
+	}
+
+	// a service containing : would reach into the keys of another
+	invalid := &Namespace{Service: "orders:v1", Version: 1}
+	if _, err := invalid.Flush(); err != ErrInvalidNamespace {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "flush orders:v1", ErrInvalidNamespace, err)
+	}
+	if _, err := invalid.Purge(); err != ErrInvalidNamespace {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "purge orders:v1", ErrInvalidNamespace, err)
+	}
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/datastore"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mousybusiness/googlecloudgo/pkg/bq"
+	"github.com/mousybusiness/googlecloudgo/pkg/ds"
+	errs "github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPreloadBatch = 500
+
+// PreloadSource yields the records used to warm the cache
+// Next returns iterator.Done once the source is exhausted
+type PreloadSource interface {
+	Next() (interface{}, error)
+}
+
+// KeyFunc extracts the redis key from a record
+type KeyFunc func(record interface{}) (string, error)
+
+// EncodeFunc converts a record to the value stored in redis
+type EncodeFunc func(record interface{}) ([]byte, error)
+
+type PreloadOptions struct {
+	Key       KeyFunc       // required
+	Encode    EncodeFunc    // defaults to EncodeJSON
+	TTL       time.Duration // 0 means keys don't expire
+	BatchSize int           // SETs pipelined per round trip, defaults to 500
+}
+
+// Preload reads every record from src and writes it to redis, returning the number of keys written
+func Preload(ctx context.Context, src PreloadSource, opts PreloadOptions) (int, error) {
+	if opts.Key == nil {
+		return 0, errors.New("key function required")
+	}
+	if opts.Encode == nil {
+		opts.Encode = EncodeJSON
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultPreloadBatch
+	}
+
+	if cacheFailed {
+		log.Println("previous cache attempts have failed")
+		return 0, errors.New("cache isn't reachable")
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	written, pending := 0, 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		if err := conn.Flush(); err != nil {
+			return errs.Wrap(err, "failed to flush preload batch")
+		}
+		for ; pending > 0; pending-- {
+			if _, err := conn.Receive(); err != nil {
+				return errs.Wrap(err, "failed to set preloaded key")
+			}
+			written++
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		record, err := src.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return written, errs.Wrap(err, "failed to read preload source")
+		}
+
+		key, err := opts.Key(record)
+		if err != nil {
+			return written, errs.Wrap(err, "failed to extract key")
+		}
+		value, err := opts.Encode(record)
+		if err != nil {
+			return written, errs.Wrap(err, fmt.Sprintf("failed to encode %s", key))
+		}
+
+		args := []interface{}{key, value}
+		if opts.TTL > 0 {
+			args = append(args, "PX", opts.TTL.Milliseconds())
+		}
+		if err := conn.Send("SET", args...); err != nil {
+			return written, errs.Wrap(err, "failed to queue set")
+		}
+
+		pending++
+		if pending >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return written, err
+	}
+
+	log.Println("preloaded", written, "keys")
+	return written, nil
+}
+
+// IteratorSource adapts any iterator function to a PreloadSource
+type IteratorSource func() (interface{}, error)
+
+func (f IteratorSource) Next() (interface{}, error) {
+	return f()
+}
+
+// SliceSource yields each element of records
+func SliceSource(records ...interface{}) PreloadSource {
+	i := 0
+	return IteratorSource(func() (interface{}, error) {
+		if i >= len(records) {
+			return nil, iterator.Done
+		}
+		i++
+		return records[i-1], nil
+	})
+}
+
+// BQSource yields each row of the query as []bigquery.Value
+func BQSource(query string) (PreloadSource, error) {
+	rows, err := bq.QueryRows(query)
+	if err != nil {
+		return nil, errs.Wrap(err, "error while querying BQ")
+	}
+
+	records := make([]interface{}, len(rows))
+	for i, r := range rows {
+		records[i] = r
+	}
+	return SliceSource(records...), nil
+}
+
+// DatastoreRecord is yielded by DatastoreSource
+type DatastoreRecord struct {
+	Key    *datastore.Key
+	Entity interface{}
+}
+
+// DatastoreSource yields every entity of kind as a DatastoreRecord
+// newEntity must return a pointer to a struct the entities are loaded into
+func DatastoreSource(ctx context.Context, client ds.DatastoreClient, kind string, newEntity func() interface{}) PreloadSource {
+	it := client.Client().Run(ctx, datastore.NewQuery(kind))
+	return IteratorSource(func() (interface{}, error) {
+		entity := newEntity()
+		key, err := it.Next(entity)
+		if err != nil {
+			return nil, err
+		}
+		return DatastoreRecord{Key: key, Entity: entity}, nil
+	})
+}
+
+// ColumnKey uses a column of a BigQuery row as the key
+func ColumnKey(column int) KeyFunc {
+	return func(record interface{}) (string, error) {
+		row, ok := record.([]bigquery.Value)
+		if !ok {
+			return "", fmt.Errorf("expected []bigquery.Value, got %T", record)
+		}
+		if column < 0 || column >= len(row) {
+			return "", fmt.Errorf("column %d out of range, row has %d columns", column, len(row))
+		}
+		return fmt.Sprint(row[column]), nil
+	}
+}
+
+// DatastoreKey uses the name or id of the entity key, prefixed with prefix
+func DatastoreKey(prefix string) KeyFunc {
+	return func(record interface{}) (string, error) {
+		r, ok := record.(DatastoreRecord)
+		if !ok || r.Key == nil {
+			return "", fmt.Errorf("expected DatastoreRecord with key, got %T", record)
+		}
+		if r.Key.Name != "" {
+			return prefix + r.Key.Name, nil
+		}
+		return prefix + strconv.FormatInt(r.Key.ID, 10), nil
+	}
+}
+
+// FieldKey uses a struct field of the record (or the entity of a DatastoreRecord) as the key
+func FieldKey(field string) KeyFunc {
+	return func(record interface{}) (string, error) {
+		if r, ok := record.(DatastoreRecord); ok {
+			record = r.Entity
+		}
+
+		v := reflect.Indirect(reflect.ValueOf(record))
+		if v.Kind() != reflect.Struct {
+			return "", fmt.Errorf("expected struct, got %T", record)
+		}
+		f := v.FieldByName(field)
+		if !f.IsValid() {
+			return "", fmt.Errorf("field %s not found on %T", field, record)
+		}
+		return fmt.Sprint(f.Interface()), nil
+	}
+}
+
+// EncodeJSON stores records as JSON, DatastoreRecords store only their entity
+func EncodeJSON(record interface{}) ([]byte, error) {
+	if r, ok := record.(DatastoreRecord); ok {
+		record = r.Entity
+	}
+	return json.Marshal(record)
+}
+
+// EncodeCSV joins the values of a BigQuery row with commas
+func EncodeCSV(record interface{}) ([]byte, error) {
+	row, ok := record.([]bigquery.Value)
+	if !ok {
+		return nil, fmt.Errorf("expected []bigquery.Value, got %T", record)
+	}
+
+	s := make([]string, len(row))
+	for i, v := range row {
+		s[i] = fmt.Sprint(v)
+	}
+	return []byte(strings.Join(s, ",")), nil
+}
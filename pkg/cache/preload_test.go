@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+	"testing"
+)
+
+type product struct {
+	SKU   string
+	Price int
+}
+
+func TestKeyFuncs(t *testing.T) {
+	row := []bigquery.Value{"abc", "12", "red"}
+	named := DatastoreRecord{Key: datastore.NameKey("Product", "abc", nil), Entity: &product{SKU: "sku-1"}}
+	id := DatastoreRecord{Key: datastore.IDKey("Product", 42, nil), Entity: &product{}}
+
+	tt := []struct {
+		name     string
+		key      KeyFunc
+		record   interface{}
+		expected string
+		fails    bool
+	}{
+		{"column", ColumnKey(1), row, "12", false},
+		{"column out of range", ColumnKey(3), row, "", true},
+		{"column wrong type", ColumnKey(0), "abc,12", "", true},
+		{"datastore name", DatastoreKey("product:"), named, "product:abc", false},
+		{"datastore id", DatastoreKey("product:"), id, "product:42", false},
+		{"field of entity", FieldKey("SKU"), named, "sku-1", false},
+		{"field of struct", FieldKey("Price"), product{Price: 3}, "3", false},
+		{"missing field", FieldKey("Colour"), product{}, "", true},
+	}
+
+	for _, tc := range tt {
+		key, err := tc.key(tc.record)
+		if (err != nil) != tc.fails || key != tc.expected {
+			t.Errorf("%s failed; wanted: %q, got: %q, err: %v", tc.name, tc.expected, key, err)
+		}
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	b, err := EncodeCSV([]bigquery.Value{"abc", "12"})
+	if err != nil || string(b) != "abc,12" {
+		t.Errorf("csv failed; got: %s, err: %v", b, err)
+	}
+
+	b, err = EncodeJSON(DatastoreRecord{Key: datastore.IDKey("Product", 1, nil), Entity: &product{SKU: "a", Price: 1}})
+	if err != nil || string(b) != `{"SKU":"a","Price":1}` {
+		t.Errorf("json failed; got: %s, err: %v", b, err)
+	}
+}
+
+func TestSliceSource(t *testing.T) {
+	src := SliceSource("a", "b")
+	for _, want := range []string{"a", "b"} {
+		if r, err := src.Next(); err != nil || r != want {
+			t.Fatalf("wanted: %s, got: %v, err: %v", want, r, err)
+		}
+	}
+	if _, err := src.Next(); err != iterator.Done {
+		t.Fatalf("wanted iterator.Done, got: %v", err)
+	}
+}
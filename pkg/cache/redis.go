@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/gomodule/redigo/redis"
@@ -52,6 +54,31 @@ func Set(key string, value []byte) error {
 	return err
 }
 
+// set value to redis, expiring after ttl
+func SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := redis.DoWithTimeout(conn, time.Millisecond*100, "SET", key, value, "PX", ttl.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("error setting key %s with ttl %v: %v", key, ttl, err)
+	}
+	return nil
+}
+
+// set value to redis only if key doesn't exist, expiring after ttl
+// returns false when the key was already set
+func SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	r, err := redis.DoWithTimeout(conn, time.Millisecond*100, "SET", key, value, "NX", "PX", ttl.Milliseconds())
+	if err != nil {
+		return false, fmt.Errorf("error setting key %s if absent: %v", key, err)
+	}
+	return r != nil, nil
+}
+
 func Ping(c redis.Conn) error {
 	s, err := redis.String(c.Do("PING"))
 	if err != nil {
@@ -80,20 +107,47 @@ func IncrementCounter(key string) (int, error) {
 	return counter, nil
 }
 
+// Available reports whether redis is reachable, callers should bypass the cache when it isn't
+func Available() bool {
+	return !cacheFailed
+}
+
 // if cache has failed (or first run) poll for redis instance information
+// in direct mode the configured addresses are used instead of Memorystore
 func ValidateMemoryStoreAndCreatePool() {
+	if cacheFailed && DirectMode() {
+		addr, err := direct.Resolve()
+		if err != nil {
+			log.Println("FAILED TO GET REDIS!", err)
+			return
+		}
+
+		log.Println("using direct redis address:", addr)
+		cacheFailed = false
+		pool = direct.Pool(addr)
+		return
+	}
+
 	if cacheFailed {
 		instance, err := FindCacheInstance()
-		if err != nil || instance.Host == "" || instance.Port == 0 {
-			log.Println("FAILED TO GET REDIS!", err)
-			cacheFailed = true
-		} else {
-			log.Println("REDIS IS LIVE!")
-			cacheFailed = false
-			redisAddr := fmt.Sprintf("%s:%d", instance.Host, instance.Port)
-			log.Println("using redis address:", redisAddr)
-			pool = NewPool(redisAddr)
+		if err == nil {
+			var endpoint Endpoint
+			endpoint, err = InstanceEndpoint(instance)
+			if err == nil {
+				var p *redis.Pool
+				p, err = memorystorePool(endpoint)
+				if err == nil {
+					log.Println("REDIS IS LIVE!")
+					cacheFailed = false
+					log.Println("using redis address:", endpoint.Addr())
+					pool = p
+					return
+				}
+			}
 		}
+
+		log.Println("FAILED TO GET REDIS!", err)
+		cacheFailed = true
 	}
 }
 
@@ -118,3 +172,30 @@ func NewPool(addr string) *redis.Pool {
 		},
 	}
 }
+
+// create a redis connection pool for a Memorystore endpoint
+// password is the instance's auth string, used when AUTH is enabled
+func NewEndpointPool(e Endpoint, password string) (*redis.Pool, error) {
+	options := []redis.DialOption{redis.DialConnectTimeout(time.Millisecond * 100)}
+	if e.AuthEnabled {
+		options = append(options, redis.DialPassword(password))
+	}
+	if e.TLS {
+		roots := x509.NewCertPool()
+		for _, cert := range e.CACerts {
+			if !roots.AppendCertsFromPEM([]byte(cert)) {
+				return nil, errors.New("invalid server ca certificate")
+			}
+		}
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(&tls.Config{RootCAs: roots}))
+	}
+
+	addr := e.Addr()
+	return &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr, options...)
+		},
+	}, nil
+}
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultResponseTTL = time.Minute
+	responseKeyPrefix  = "response:"
+	// matches auth.FirebaseContextVal, duplicated to avoid an import cycle
+	firebaseContextVal = "FIREBASE_ID_TOKEN"
+	cacheStatusHeader  = "X-Cache"
+)
+
+type ResponseCacheOptions struct {
+	TTL            time.Duration // used when the response has no Cache-Control max-age, defaults to 1 minute
+	Namespace      *Namespace    // keys are prefixed with response: when nil
+	VaryByUser     bool          // include the Firebase UID set by auth.AuthJWT in the key
+	UserContextKey string        // defaults to auth.FirebaseContextVal
+}
+
+// cached response
+type responseEntry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	ETag   string      `json:"etag"`
+}
+
+// buffers the body so the ETag can be set before anything is written
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// CacheResponses is gin middleware caching successful GET and HEAD responses in redis
+// responses are keyed by method, path, query and optionally user, and served with an ETag so
+// If-None-Match requests get a 304. The cache is bypassed while redis is unavailable
+// hits skip the remaining handlers, so mount it after any auth middleware e.g. auth.AuthJWT
+// with VaryByUser requests without a user token aren't cached
+func CacheResponses(opts ResponseCacheOptions) gin.HandlerFunc {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultResponseTTL
+	}
+	if opts.UserContextKey == "" {
+		opts.UserContextKey = firebaseContextVal
+	}
+
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		reqCache := c.Request.Header.Get("Cache-Control")
+		if (method != http.MethodGet && method != http.MethodHead) || hasDirective(reqCache, "no-store") || !Available() {
+			c.Next()
+			return
+		}
+
+		uid := ""
+		if opts.VaryByUser {
+			if t, ok := c.Get(opts.UserContextKey); ok {
+				if token, ok := t.(*fbauth.Token); ok {
+					uid = token.UID
+				}
+			}
+			// otherwise one user's response would be cached for everyone
+			if uid == "" {
+				c.Next()
+				return
+			}
+		}
+
+		key, err := responseKey(opts.Namespace, method, c.Request.URL.Path, c.Request.URL.Query(), uid)
+		if err != nil {
+			log.Println("failed to build response cache key:", err)
+			c.Next()
+			return
+		}
+
+		// no-cache requires revalidation so skip the lookup but still store the fresh response
+		if !hasDirective(reqCache, "no-cache") {
+			if b, err := Get(key); err == nil {
+				var entry responseEntry
+				if err := json.Unmarshal(b, &entry); err == nil {
+					writeEntry(c, entry, "HIT")
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		original := c.Writer
+		w := &bufferedWriter{ResponseWriter: original}
+		c.Writer = w
+		c.Next()
+		c.Writer = original
+
+		entry := responseEntry{
+			Status: w.Status(),
+			Header: original.Header().Clone(),
+			Body:   w.body.Bytes(),
+		}
+		entry.Header.Del("Set-Cookie")
+
+		respCache := original.Header().Get("Cache-Control")
+		ttl := opts.TTL
+		if age, ok := maxAge(respCache); ok {
+			ttl = age
+		}
+
+		cacheable := entry.Status == http.StatusOK && ttl > 0 &&
+			!hasDirective(respCache, "no-store") && (uid != "" || !hasDirective(respCache, "private"))
+		if cacheable {
+			entry.ETag = etag(entry.Body)
+			if b, err := json.Marshal(entry); err == nil {
+				if err := SetWithTTL(key, b, ttl); err != nil {
+					log.Println("failed to cache response:", err)
+				}
+			}
+		}
+
+		writeEntry(c, entry, "MISS")
+	}
+}
+
+func writeEntry(c *gin.Context, entry responseEntry, status string) {
+	h := c.Writer.Header()
+	for k, v := range entry.Header {
+		h[k] = v
+	}
+	h.Set(cacheStatusHeader, status)
+
+	if entry.ETag != "" {
+		h.Set("ETag", entry.ETag)
+		if match := c.Request.Header.Get("If-None-Match"); match != "" && etagMatches(match, entry.ETag) {
+			c.Writer.WriteHeader(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+	}
+
+	c.Writer.WriteHeader(entry.Status)
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	if _, err := c.Writer.Write(entry.Body); err != nil {
+		log.Println("failed to write response:", err)
+	}
+}
+
+// hash of method, path, query and user so the key length is bounded
+// every part is length prefixed so different requests can't hash the same input, parameters are
+// sorted by name but their values keep their order as ?a=1&a=2 and ?a=2&a=1 may differ to the handler
+func responseKey(ns *Namespace, method string, path string, query map[string][]string, uid string) (string, error) {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(strconv.Itoa(len(s)) + ":" + s))
+	}
+	write(method)
+	write(path)
+	write(uid)
+	for _, k := range keys {
+		write(k)
+		write(strconv.Itoa(len(query[k])))
+		for _, v := range query[k] {
+			write(v)
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	if ns == nil {
+		return responseKeyPrefix + sum, nil
+	}
+	return ns.Key(sum)
+}
+
+func etag(body []byte) string {
+	sum := sha1.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func etagMatches(header string, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDirective(cacheControl string, directive string) bool {
+	for _, d := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(d), directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// s-maxage takes precedence over max-age as this is a shared cache
+func maxAge(cacheControl string) (time.Duration, bool) {
+	var age time.Duration
+	found := false
+	for _, d := range strings.Split(cacheControl, ",") {
+		parts := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.ToLower(parts[0])
+		if name != "max-age" && name != "s-maxage" {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.Trim(parts[1], `"`))
+		if err != nil {
+			continue
+		}
+
+		if name == "s-maxage" || !found {
+			age, found = time.Duration(secs)*time.Second, true
+		}
+	}
+	return age, found
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	fbauth "firebase.google.com/go/auth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaxAge(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected time.Duration
+		found    bool
+	}{
+		{"max-age=60", time.Minute, true},
+		{"public, max-age=30", 30 * time.Second, true},
+		{"max-age=30, s-maxage=120", 2 * time.Minute, true},
+		{"s-maxage=120, max-age=30", 2 * time.Minute, true},
+		{"no-cache", 0, false},
+		{"max-age=abc", 0, false},
+	}
+
+	for _, test := range tests {
+		if output, found := maxAge(test.input); output != test.expected || found != test.found {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tag := etag([]byte("body"))
+	if !etagMatches(tag, tag) || !etagMatches(`"other", W/`+tag, tag) || !etagMatches("*", tag) {
+		t.Errorf("expected etag to match")
+	}
+	if etagMatches(`"other"`, tag) {
+		t.Errorf("expected etag not to match")
+	}
+}
+
+func TestResponseKey(t *testing.T) {
+	a, _ := responseKey(nil, "GET", "/items", map[string][]string{"a": {"1"}, "b": {"2", "3"}}, "")
+	b, _ := responseKey(nil, "GET", "/items", map[string][]string{"b": {"2", "3"}, "a": {"1"}}, "")
+	c, _ := responseKey(nil, "GET", "/items", map[string][]string{"a": {"1"}, "b": {"2", "3"}}, "uid")
+
+	if a != b {
+		t.Errorf("parameter order changed key; %s != %s", a, b)
+	}
+
+	// each pair must hash differently
+	var collisions = [][2]map[string][]string{
+		{{"a": {"1,2"}}, {"a": {"1", "2"}}},
+		{{"b": {"3", "2"}}, {"b": {"2", "3"}}},
+		{{"a": {"1\nb=2"}}, {"a": {"1"}, "b": {"2"}}},
+		{{"a=1": {""}}, {"a": {"1="}}},
+	}
+	for _, test := range collisions {
+		x, _ := responseKey(nil, "GET", "/items", test[0], "")
+		y, _ := responseKey(nil, "GET", "/items", test[1], "")
+		if x == y {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test, "different keys", x)
+		}
+	}
+	if a == c {
+		t.Errorf("user didn't change key")
+	}
+}
+
+func TestCacheResponsesBypassWhenUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cacheFailed = true
+
+	calls := 0
+	r := gin.New()
+	r.Use(CacheResponses(ResponseCacheOptions{}))
+	r.GET("/items", func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"items": []string{}})
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+		if w.Code != http.StatusOK || w.Header().Get(cacheStatusHeader) != "" {
+			t.Fatalf("expected uncached response, got: %d %v", w.Code, w.Header())
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("handler should run for every request when redis is unavailable, ran: %d", calls)
+	}
+}
+
+func TestCacheResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useFakeRedis(t)
+
+	calls := 0
+	r := gin.New()
+	r.Use(CacheResponses(ResponseCacheOptions{}))
+	r.GET("/items", func(c *gin.Context) {
+		calls++
+		http.SetCookie(c.Writer, &http.Cookie{Name: "visitor", Value: "abc"})
+		c.String(http.StatusOK, "items")
+	})
+
+	miss := httptest.NewRecorder()
+	r.ServeHTTP(miss, httptest.NewRequest(http.MethodGet, "/items", nil))
+	tag := miss.Header().Get("ETag")
+	if miss.Code != http.StatusOK || miss.Header().Get(cacheStatusHeader) != "MISS" || tag == "" {
+		t.Fatalf("test failed; input: %v, wanted: %v, got: %v %v", "first request", "200 MISS", miss.Code, miss.Header())
+	}
+
+	tt := []struct {
+		name        string
+		ifNoneMatch string
+		expected    int
+		body        string
+	}{
+		{"hit", "", http.StatusOK, "items"},
+		{"if none match", tag, http.StatusNotModified, ""},
+		{"other etag", `"other"`, http.StatusOK, "items"},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		if tc.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.expected || w.Body.String() != tc.body || w.Header().Get(cacheStatusHeader) != "HIT" {
+			t.Errorf("test failed; input: %v, wanted: %v %v HIT, got: %v %v %v", tc.name, tc.expected, tc.body, w.Code, w.Body.String(), w.Header().Get(cacheStatusHeader))
+		}
+		// cookies of the first visitor mustn't be served to others
+		if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", tc.name, "no Set-Cookie", cookie)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "handler calls", 1, calls)
+	}
+}
+
+func TestCacheResponsesVaryByUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useFakeRedis(t)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if uid := c.GetHeader("X-User"); uid != "" {
+			c.Set(firebaseContextVal, &fbauth.Token{UID: uid})
+		}
+	})
+	r.Use(CacheResponses(ResponseCacheOptions{VaryByUser: true}))
+	r.GET("/me", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello "+c.GetHeader("X-User"))
+	})
+
+	tt := []struct {
+		name   string
+		user   string
+		body   string
+		status string
+	}{
+		{"alice miss", "alice", "hello alice", "MISS"},
+		{"bob miss", "bob", "hello bob", "MISS"},
+		{"alice hit", "alice", "hello alice", "HIT"},
+		{"no token", "", "hello ", ""},
+		{"no token again", "", "hello ", ""},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Body.String() != tc.body || w.Header().Get(cacheStatusHeader) != tc.status {
+			t.Errorf("test failed; input: %v, wanted: %v %v, got: %v %v", tc.name, tc.body, tc.status, w.Body.String(), w.Header().Get(cacheStatusHeader))
+		}
+	}
+}
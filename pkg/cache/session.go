@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	errs "github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SessionContextVal = "CACHE_SESSION"
+
+	defaultSessionCookie = "session"
+	defaultSessionTTL    = 24 * time.Hour
+	sessionKeyPrefix     = "session:"
+	sessionIDBytes       = 32
+)
+
+var ErrInvalidSession = errors.New("invalid session cookie")
+
+type SessionOptions struct {
+	Secret     []byte        // HMAC key signing the session id cookie, required
+	CookieName string        // defaults to session
+	TTL        time.Duration // sliding expiration, defaults to 24 hours
+	Path       string        // defaults to /
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+}
+
+// SessionManager stores server side session state in redis hashes keyed by a signed session id cookie
+type SessionManager struct {
+	opts SessionOptions
+}
+
+func NewSessionManager(opts SessionOptions) (*SessionManager, error) {
+	if len(opts.Secret) < 32 {
+		return nil, errors.New("session secret must be at least 32 bytes")
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = defaultSessionCookie
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultSessionTTL
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	return &SessionManager{opts: opts}, nil
+}
+
+// Session is the state of a single client, changes are saved once the request has been handled
+type Session struct {
+	ID     string
+	Values map[string]string
+
+	m        *SessionManager
+	c        *gin.Context
+	isNew    bool
+	dirty    bool
+	previous string // id replaced by Rotate
+}
+
+func (s *Session) Get(key string) string {
+	return s.Values[key]
+}
+
+func (s *Session) Set(key string, value string) {
+	s.Values[key] = value
+	s.markDirty()
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.Values, key)
+	s.markDirty()
+}
+
+// Rotate issues a new session id keeping the values, call it whenever privileges change
+// e.g. after login or auth.ElevateToAdmin so a previously leaked id can't be used
+func (s *Session) Rotate() error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+
+	if !s.isNew && s.previous == "" {
+		s.previous = s.ID
+	}
+	s.ID = id
+	s.isNew = true
+	s.dirty = false
+	s.markDirty()
+	return nil
+}
+
+// Destroy removes the session from redis and clears the cookie
+func (s *Session) Destroy() error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	keys := []interface{}{sessionKey(s.ID)}
+	if s.previous != "" {
+		keys = append(keys, sessionKey(s.previous))
+	}
+	if _, err := redis.DoWithTimeout(conn, time.Millisecond*100, "UNLINK", keys...); err != nil {
+		return errs.Wrap(err, "failed to delete session")
+	}
+
+	s.Values = map[string]string{}
+	s.dirty = false
+	s.m.clearCookie(s.c)
+	return nil
+}
+
+// the cookie must be written before the response body so it's set as soon as the session changes
+func (s *Session) markDirty() {
+	if !s.dirty {
+		s.m.setCookie(s.c, s.ID)
+	}
+	s.dirty = true
+}
+
+// GetSession returns the session loaded by SessionManager.Middleware
+func GetSession(c *gin.Context) *Session {
+	if v, ok := c.Get(SessionContextVal); ok {
+		if s, ok := v.(*Session); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// Middleware loads the session into the gin context under SessionContextVal and saves it after the handlers
+func (m *SessionManager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Available() {
+			log.Println("cache unavailable, can't load session")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": http.StatusText(http.StatusServiceUnavailable),
+			})
+			return
+		}
+
+		s, err := m.Load(c)
+		if err != nil {
+			log.Println("failed to load session:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": http.StatusText(http.StatusInternalServerError),
+			})
+			return
+		}
+
+		c.Set(SessionContextVal, s)
+		c.Next()
+
+		if err := m.Save(s); err != nil {
+			log.Println("failed to save session:", err)
+		}
+	}
+}
+
+// Load returns the session of the request's cookie, or a new empty session
+func (m *SessionManager) Load(c *gin.Context) (*Session, error) {
+	if cookie, err := c.Cookie(m.opts.CookieName); err == nil {
+		id, err := m.verify(cookie)
+		if err == nil {
+			values, err := loadSession(id)
+			if err != nil {
+				return nil, err
+			}
+			if values != nil {
+				s := &Session{ID: id, Values: values, m: m, c: c}
+				// sliding expiration
+				m.setCookie(c, id)
+				return s, touchSession(id, m.opts.TTL)
+			}
+		}
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, Values: map[string]string{}, m: m, c: c, isNew: true}, nil
+}
+
+// Save writes changed sessions to redis, replacing the hash and resetting the expiry
+func (m *SessionManager) Save(s *Session) error {
+	if !s.dirty {
+		return nil
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	// stops queueing at the first failed send, the pool discards the open MULTI when the conn is closed
+	var err error
+	send := func(cmd string, args ...interface{}) {
+		if err == nil {
+			err = conn.Send(cmd, args...)
+		}
+	}
+
+	key := sessionKey(s.ID)
+	send("MULTI")
+	send("DEL", key)
+	if s.previous != "" {
+		send("UNLINK", sessionKey(s.previous))
+	}
+	if len(s.Values) > 0 {
+		args := []interface{}{key}
+		for k, v := range s.Values {
+			args = append(args, k, v)
+		}
+		send("HSET", args...)
+		send("PEXPIRE", key, m.opts.TTL.Milliseconds())
+	}
+	if err != nil {
+		return errs.Wrap(err, "failed to queue session save")
+	}
+
+	results, err := redis.Values(redis.DoWithTimeout(conn, time.Millisecond*100, "EXEC"))
+	if err != nil {
+		return errs.Wrap(err, "failed to save session")
+	}
+	// EXEC succeeds even when commands inside it fail
+	for _, r := range results {
+		if e, ok := r.(redis.Error); ok {
+			return errs.Wrap(e, "failed to save session")
+		}
+	}
+
+	s.dirty, s.isNew, s.previous = false, false, ""
+	return nil
+}
+
+// replaces any session cookie already set on the response
+func (m *SessionManager) setCookie(c *gin.Context, id string) {
+	m.removeCookie(c)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    m.sign(id),
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   int(m.opts.TTL.Seconds()),
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+func (m *SessionManager) clearCookie(c *gin.Context) {
+	m.removeCookie(c)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   -1,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+func (m *SessionManager) removeCookie(c *gin.Context) {
+	h := c.Writer.Header()
+	var keep []string
+	for _, v := range h.Values("Set-Cookie") {
+		if !strings.HasPrefix(v, m.opts.CookieName+"=") {
+			keep = append(keep, v)
+		}
+	}
+	h.Del("Set-Cookie")
+	for _, v := range keep {
+		h.Add("Set-Cookie", v)
+	}
+}
+
+// id.signature
+func (m *SessionManager) sign(id string) string {
+	mac := hmac.New(sha256.New, m.opts.Secret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *SessionManager) verify(cookie string) (string, error) {
+	i := strings.LastIndex(cookie, ".")
+	if i <= 0 {
+		return "", ErrInvalidSession
+	}
+
+	id := cookie[:i]
+	if !hmac.Equal([]byte(m.sign(id)), []byte(cookie)) {
+		return "", ErrInvalidSession
+	}
+	return id, nil
+}
+
+// returns nil if the session doesn't exist
+func loadSession(id string) (map[string]string, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(redis.DoWithTimeout(conn, time.Millisecond*100, "HGETALL", sessionKey(id)))
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to load session")
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values, nil
+}
+
+func touchSession(id string, ttl time.Duration) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := redis.DoWithTimeout(conn, time.Millisecond*100, "PEXPIRE", sessionKey(id), ttl.Milliseconds())
+	return err
+}
+
+func sessionKey(id string) string {
+	return sessionKeyPrefix + id
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errs.Wrap(err, "failed to generate session id")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionCookieSignature(t *testing.T) {
+	m, err := NewSessionManager(SessionOptions{Secret: []byte(strings.Repeat("s", 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewSessionManager(SessionOptions{Secret: []byte(strings.Repeat("o", 32))})
+
+	cookie := m.sign("abc")
+	if id, err := m.verify(cookie); err != nil || id != "abc" {
+		t.Fatalf("valid cookie rejected; got: %s, err: %v", id, err)
+	}
+
+	for _, c := range []string{"abc", "abd" + cookie[3:], other.sign("abc"), "", "."} {
+		if _, err := m.verify(c); err != ErrInvalidSession {
+			t.Errorf("expected %q to be rejected, got: %v", c, err)
+		}
+	}
+}
+
+func TestNewSessionManagerRequiresSecret(t *testing.T) {
+	if _, err := NewSessionManager(SessionOptions{Secret: []byte("short")}); err == nil {
+		t.Fatalf("expected short secret to be rejected")
+	}
+}
+
+func TestSessionMiddlewareUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cacheFailed = true
+
+	m, _ := NewSessionManager(SessionOptions{Secret: []byte(strings.Repeat("s", 32))})
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/", func(c *gin.Context) {
+		t.Fatalf("handler shouldn't run without a session store")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got: %d", w.Code)
+	}
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := useFakeRedis(t)
+
+	m, _ := NewSessionManager(SessionOptions{Secret: []byte(strings.Repeat("s", 32))})
+	router := gin.New()
+	router.Use(m.Middleware())
+	router.GET("/set", func(c *gin.Context) {
+		GetSession(c).Set("user", c.Query("user"))
+	})
+	router.GET("/get", func(c *gin.Context) {
+		c.String(http.StatusOK, GetSession(c).Get("user"))
+	})
+	router.GET("/rotate", func(c *gin.Context) {
+		GetSession(c).Rotate()
+	})
+	router.GET("/destroy", func(c *gin.Context) {
+		GetSession(c).Destroy()
+	})
+
+	request := func(path string, cookie string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: defaultSessionCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == defaultSessionCookie {
+				return w.Body.String(), c.Value
+			}
+		}
+		return w.Body.String(), ""
+	}
+
+	_, saved := request("/set?user=alice", "")
+	id, _ := m.verify(saved)
+	if r.hashes[sessionKey(id)]["user"] != "alice" || r.ttls[sessionKey(id)] != defaultSessionTTL.Milliseconds() {
+		t.Fatalf("test failed; input: %v, wanted: %v, got: %v %v", "save", "alice", r.hashes, r.ttls)
+	}
+
+	if body, _ := request("/get", saved); body != "alice" {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "load", "alice", body)
+	}
+
+	_, rotated := request("/rotate", saved)
+	newID, _ := m.verify(rotated)
+	if rotated == "" || newID == id || r.hashes[sessionKey(id)] != nil || r.hashes[sessionKey(newID)]["user"] != "alice" {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v %v", "rotate", "values moved to a new id", rotated, r.hashes)
+	}
+	if body, _ := request("/get", saved); body != "" {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "old id after rotate", "", body)
+	}
+
+	request("/destroy", rotated)
+	if len(r.keys()) != 0 {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "destroy", "no keys", r.keys())
+	}
+}
+
+func TestSessionSaveFailure(t *testing.T) {
+	r := useFakeRedis(t)
+	r.fail["HSET"] = errors.New("OOM command not allowed")
+
+	m, _ := NewSessionManager(SessionOptions{Secret: []byte(strings.Repeat("s", 32))})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	s, _ := m.Load(c)
+	s.Set("user", "alice")
+
+	if err := m.Save(s); err == nil {
+		t.Errorf("test failed; input: %v, wanted: %v, got: %v", "failed HSET", "error", err)
+	}
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	"errors"
+	"fmt"
+	errs "github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/type/dayofweek"
+	"google.golang.org/genproto/googleapis/type/timeofday"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// returned when an existing instance differs from the spec in a field which can't be updated in place
+var ErrImmutableSpec = errors.New("instance differs from spec in an immutable field")
+
+// InstanceSpec describes how a Memorystore Redis instance should be provisioned
+type InstanceSpec struct {
+	Project           string
+	Region            string
+	InstanceID        string
+	DisplayName       string
+	RedisVersion      string
+	Tier              redispb.Instance_Tier // BASIC or STANDARD_HA
+	MemorySizeGb      int32
+	LocationID        string // zone, optional
+	AlternativeZone   string // STANDARD_HA replica zone, optional
+	AuthorizedNetwork string // e.g. projects/my-project/global/networks/default
+	ConnectMode       redispb.Instance_ConnectMode
+	ReservedIPRange   string
+	RedisConfigs      map[string]string
+	Labels            map[string]string
+	MaintenanceWindow *MaintenanceWindow // optional, Memorystore picks a window when nil
+	AuthEnabled       bool
+	TransitEncryption bool // can only be set when the instance is created
+}
+
+// MaintenanceWindow is the weekly window in which Memorystore may perform maintenance
+type MaintenanceWindow struct {
+	Day       time.Weekday
+	StartHour int // UTC
+}
+
+// SpecFromEnv returns a 1GB BASIC spec using GOOGLE_CLOUD_PROJECT, REGION, MEMORYSTORE_INSTANCE and REDIS_VERSION
+func SpecFromEnv() InstanceSpec {
+	return InstanceSpec{
+		Project:      projectId,
+		Region:       region,
+		InstanceID:   instanceId,
+		DisplayName:  instanceId,
+		RedisVersion: redisVersion,
+		Tier:         redispb.Instance_BASIC,
+		MemorySizeGb: 1,
+	}
+}
+
+// Parent returns the location the instance belongs to
+func (s InstanceSpec) Parent() string {
+	return fmt.Sprintf("projects/%s/locations/%s", s.Project, s.Region)
+}
+
+// Name returns the full resource name of the instance
+func (s InstanceSpec) Name() string {
+	return fmt.Sprintf("%s/instances/%s", s.Parent(), s.InstanceID)
+}
+
+// Validate checks the spec can be provisioned
+func (s InstanceSpec) Validate() error {
+	if s.Project == "" || s.Region == "" || s.InstanceID == "" {
+		return errors.New("project, region and instance id required")
+	}
+	if s.MemorySizeGb <= 0 {
+		return errors.New("memory size must be at least 1GB")
+	}
+	if s.Tier != redispb.Instance_BASIC && s.Tier != redispb.Instance_STANDARD_HA {
+		return errors.New("tier must be BASIC or STANDARD_HA")
+	}
+	if s.AlternativeZone != "" && s.Tier != redispb.Instance_STANDARD_HA {
+		return errors.New("alternative zone requires STANDARD_HA tier")
+	}
+	if w := s.MaintenanceWindow; w != nil {
+		if w.Day < time.Sunday || w.Day > time.Saturday {
+			return errors.New("maintenance window day must be a weekday")
+		}
+		if w.StartHour < 0 || w.StartHour > 23 {
+			return errors.New("maintenance window start hour must be between 0 and 23")
+		}
+	}
+	return nil
+}
+
+// Instance converts the spec to the instance used to create it
+func (s InstanceSpec) Instance() *redispb.Instance {
+	return &redispb.Instance{
+		Name:                  s.Name(),
+		DisplayName:           s.DisplayName,
+		RedisVersion:          s.RedisVersion,
+		Tier:                  s.Tier,
+		MemorySizeGb:          s.MemorySizeGb,
+		LocationId:            s.LocationID,
+		AlternativeLocationId: s.AlternativeZone,
+		AuthorizedNetwork:     s.AuthorizedNetwork,
+		ConnectMode:           s.ConnectMode,
+		ReservedIpRange:       s.ReservedIPRange,
+		RedisConfigs:          s.RedisConfigs,
+		Labels:                s.Labels,
+		AuthEnabled:           s.AuthEnabled,
+		TransitEncryptionMode: s.transitEncryptionMode(),
+		MaintenancePolicy:     s.maintenancePolicy(),
+	}
+}
+
+func (s InstanceSpec) transitEncryptionMode() redispb.Instance_TransitEncryptionMode {
+	if s.TransitEncryption {
+		return redispb.Instance_SERVER_AUTHENTICATION
+	}
+	return redispb.Instance_DISABLED
+}
+
+func (s InstanceSpec) maintenancePolicy() *redispb.MaintenancePolicy {
+	if s.MaintenanceWindow == nil {
+		return nil
+	}
+	return &redispb.MaintenancePolicy{
+		WeeklyMaintenanceWindow: []*redispb.WeeklyMaintenanceWindow{{
+			Day:       weekday(s.MaintenanceWindow.Day),
+			StartTime: &timeofday.TimeOfDay{Hours: int32(s.MaintenanceWindow.StartHour)},
+		}},
+	}
+}
+
+// weekday converts a time.Weekday, which starts on Sunday, to a DayOfWeek, which starts on Monday
+func weekday(d time.Weekday) dayofweek.DayOfWeek {
+	if d == time.Sunday {
+		return dayofweek.DayOfWeek_SUNDAY
+	}
+	return dayofweek.DayOfWeek(d)
+}
+
+// windowMatches reports whether the instance's maintenance policy is the single window w
+func windowMatches(w *MaintenanceWindow, policy *redispb.MaintenancePolicy) bool {
+	windows := policy.GetWeeklyMaintenanceWindow()
+	if len(windows) != 1 {
+		return false
+	}
+	return windows[0].GetDay() == weekday(w.Day) &&
+		windows[0].GetStartTime().GetHours() == int32(w.StartHour) &&
+		windows[0].GetStartTime().GetMinutes() == 0
+}
+
+// EnsureInstance creates the instance described by spec, or updates an existing instance to match it
+func EnsureInstance(ctx context.Context, admin InstanceAdmin, spec InstanceSpec) (*redispb.Instance, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := admin.GetInstance(ctx, spec.Name())
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return nil, errs.Wrap(err, "failed to get instance")
+		}
+
+		log.Println("creating redis instance", spec.Name())
+		return admin.CreateInstance(ctx, spec.Parent(), spec.InstanceID, spec.Instance())
+	}
+
+	paths, err := specDiff(spec, existing)
+	if err != nil {
+		return existing, err
+	}
+	if len(paths) == 0 {
+		return existing, nil
+	}
+
+	if existing.GetState() != redispb.Instance_READY {
+		return existing, fmt.Errorf("instance is %s, can't update %s", existing.GetState(), strings.Join(paths, ","))
+	}
+
+	log.Println("updating redis instance", spec.Name(), paths)
+	return admin.UpdateInstance(ctx, spec.Instance(), paths...)
+}
+
+// specDiff returns the update mask paths needed to make the instance match the spec
+// optional fields left empty in the spec are ignored
+func specDiff(spec InstanceSpec, existing *redispb.Instance) ([]string, error) {
+	var immutable []string
+	check := func(field string, want, got interface{}, zero interface{}) {
+		if want != zero && want != got {
+			immutable = append(immutable, field)
+		}
+	}
+	check("tier", spec.Tier, existing.GetTier(), redispb.Instance_TIER_UNSPECIFIED)
+	check("redis_version", spec.RedisVersion, existing.GetRedisVersion(), "")
+	check("location_id", spec.LocationID, existing.GetLocationId(), "")
+	check("alternative_location_id", spec.AlternativeZone, existing.GetAlternativeLocationId(), "")
+	check("authorized_network", spec.AuthorizedNetwork, existing.GetAuthorizedNetwork(), "")
+	check("connect_mode", spec.ConnectMode, existing.GetConnectMode(), redispb.Instance_CONNECT_MODE_UNSPECIFIED)
+	check("reserved_ip_range", spec.ReservedIPRange, existing.GetReservedIpRange(), "")
+	if spec.TransitEncryption != (existing.GetTransitEncryptionMode() == redispb.Instance_SERVER_AUTHENTICATION) {
+		immutable = append(immutable, "transit_encryption_mode")
+	}
+	if len(immutable) > 0 {
+		return nil, errs.Wrap(ErrImmutableSpec, strings.Join(immutable, ","))
+	}
+
+	var paths []string
+	if spec.DisplayName != "" && spec.DisplayName != existing.GetDisplayName() {
+		paths = append(paths, "display_name")
+	}
+	if spec.MemorySizeGb != existing.GetMemorySizeGb() {
+		paths = append(paths, "memory_size_gb")
+	}
+	if spec.Labels != nil && !reflect.DeepEqual(spec.Labels, existing.GetLabels()) {
+		paths = append(paths, "labels")
+	}
+	if spec.RedisConfigs != nil && !reflect.DeepEqual(spec.RedisConfigs, existing.GetRedisConfigs()) {
+		paths = append(paths, "redis_configs")
+	}
+	if spec.AuthEnabled != existing.GetAuthEnabled() {
+		paths = append(paths, "auth_enabled")
+	}
+	if spec.MaintenanceWindow != nil && !windowMatches(spec.MaintenanceWindow, existing.GetMaintenancePolicy()) {
+		paths = append(paths, "maintenance_policy")
+	}
+	return paths, nil
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	redispb "cloud.google.com/go/redis/apiv1beta1/redispb"
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/type/dayofweek"
+	"testing"
+	"time"
+)
+
+func testSpec() InstanceSpec {
+	return InstanceSpec{
+		Project:      "p",
+		Region:       "europe-west2",
+		InstanceID:   "cache",
+		RedisVersion: "REDIS_5_0",
+		Tier:         redispb.Instance_STANDARD_HA,
+		MemorySizeGb: 2,
+		Labels:       map[string]string{"team": "web"},
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	tt := []struct {
+		name   string
+		modify func(s *InstanceSpec)
+		valid  bool
+	}{
+		{"valid", func(s *InstanceSpec) {}, true},
+		{"no memory", func(s *InstanceSpec) { s.MemorySizeGb = 0 }, false},
+		{"no instance id", func(s *InstanceSpec) { s.InstanceID = "" }, false},
+		{"no tier", func(s *InstanceSpec) { s.Tier = redispb.Instance_TIER_UNSPECIFIED }, false},
+		{"basic with replica zone", func(s *InstanceSpec) {
+			s.Tier, s.AlternativeZone = redispb.Instance_BASIC, "europe-west2-b"
+		}, false},
+		{"auth and tls", func(s *InstanceSpec) { s.AuthEnabled, s.TransitEncryption = true, true }, true},
+		{"maintenance window", func(s *InstanceSpec) {
+			s.MaintenanceWindow = &MaintenanceWindow{Day: time.Sunday, StartHour: 23}
+		}, true},
+		{"maintenance hour out of range", func(s *InstanceSpec) {
+			s.MaintenanceWindow = &MaintenanceWindow{Day: time.Sunday, StartHour: 24}
+		}, false},
+		{"maintenance day out of range", func(s *InstanceSpec) {
+			s.MaintenanceWindow = &MaintenanceWindow{Day: 7}
+		}, false},
+	}
+
+	for _, tc := range tt {
+		s := testSpec()
+		tc.modify(&s)
+		if err := s.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s failed; wanted valid: %v, got: %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestEnsureInstance(t *testing.T) {
+	admin := newFakeAdmin()
+	spec := testSpec()
+
+	created, err := EnsureInstance(context.Background(), admin, spec)
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if created.GetTier() != redispb.Instance_STANDARD_HA || created.GetMemorySizeGb() != 2 {
+		t.Fatalf("instance doesn't match spec: %v", created)
+	}
+
+	// matching spec is a no-op
+	admin.calls = nil
+	if _, err := EnsureInstance(context.Background(), admin, spec); err != nil || len(admin.calls) != 1 {
+		t.Fatalf("wanted only a get, got: %v, err: %v", admin.calls, err)
+	}
+
+	// memory can be updated in place
+	spec.MemorySizeGb = 4
+	updated, err := EnsureInstance(context.Background(), admin, spec)
+	if err != nil || updated.GetMemorySizeGb() != 4 {
+		t.Fatalf("update failed; got: %v, err: %v", updated, err)
+	}
+
+	// tier can't
+	spec.Tier = redispb.Instance_BASIC
+	if _, err := EnsureInstance(context.Background(), admin, spec); !errors.Is(err, ErrImmutableSpec) {
+		t.Fatalf("wanted immutable error, got: %v", err)
+	}
+}
+
+func TestSpecInstance(t *testing.T) {
+	spec := testSpec()
+	spec.AuthEnabled = true
+	spec.TransitEncryption = true
+	spec.MaintenanceWindow = &MaintenanceWindow{Day: time.Tuesday, StartHour: 3}
+
+	instance := spec.Instance()
+	if !instance.GetAuthEnabled() {
+		t.Error("test failed; wanted auth enabled")
+	}
+	if instance.GetTransitEncryptionMode() != redispb.Instance_SERVER_AUTHENTICATION {
+		t.Errorf("test failed; wanted: %v, got: %v", redispb.Instance_SERVER_AUTHENTICATION, instance.GetTransitEncryptionMode())
+	}
+	windows := instance.GetMaintenancePolicy().GetWeeklyMaintenanceWindow()
+	if len(windows) != 1 || windows[0].GetDay() != dayofweek.DayOfWeek_TUESDAY || windows[0].GetStartTime().GetHours() != 3 {
+		t.Errorf("test failed; wanted tuesday at 3, got: %v", windows)
+	}
+
+	if spec := testSpec(); spec.Instance().GetTransitEncryptionMode() != redispb.Instance_DISABLED || spec.Instance().GetMaintenancePolicy() != nil {
+		t.Errorf("test failed; wanted no tls or maintenance policy, got: %v", spec.Instance())
+	}
+}
+
+func TestWeekday(t *testing.T) {
+	var tests = []struct {
+		input    time.Weekday
+		expected dayofweek.DayOfWeek
+	}{
+		{time.Monday, dayofweek.DayOfWeek_MONDAY},
+		{time.Saturday, dayofweek.DayOfWeek_SATURDAY},
+		{time.Sunday, dayofweek.DayOfWeek_SUNDAY},
+	}
+
+	for _, test := range tests {
+		if output := weekday(test.input); output != test.expected {
+			t.Errorf("test failed; input: %v, wanted: %v, got: %v", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestEnsureInstanceSecurity(t *testing.T) {
+	admin := newFakeAdmin()
+	spec := testSpec()
+	if _, err := EnsureInstance(context.Background(), admin, spec); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	// auth and the maintenance window can be updated in place
+	spec.AuthEnabled = true
+	spec.MaintenanceWindow = &MaintenanceWindow{Day: time.Friday, StartHour: 22}
+	updated, err := EnsureInstance(context.Background(), admin, spec)
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if !updated.GetAuthEnabled() || !windowMatches(spec.MaintenanceWindow, updated.GetMaintenancePolicy()) {
+		t.Fatalf("instance doesn't match spec: %v", updated)
+	}
+
+	admin.calls = nil
+	if _, err := EnsureInstance(context.Background(), admin, spec); err != nil || len(admin.calls) != 1 {
+		t.Fatalf("wanted only a get, got: %v, err: %v", admin.calls, err)
+	}
+
+	// in-transit encryption can't
+	spec.TransitEncryption = true
+	if _, err := EnsureInstance(context.Background(), admin, spec); !errors.Is(err, ErrImmutableSpec) {
+		t.Fatalf("wanted immutable error, got: %v", err)
+	}
+}
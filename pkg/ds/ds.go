@@ -24,6 +24,7 @@ type DatastoreClient interface {
 	DeleteNamed(ctx context.Context, kind string, name string, parent *datastore.Key) error
 	QGet(ctx context.Context, kind string, property string, value string, entity Entity) (*datastore.Key, error)
 	QueryParent(ctx context.Context, kind string, parent *datastore.Key, entitySlicePtr interface{}) ([]*datastore.Key, error)
+	QueryProperty(ctx context.Context, kind string, property string, value string, entitySlicePtr interface{}) ([]*datastore.Key, error)
 }
 
 type Client struct {
@@ -151,12 +152,22 @@ func (client Client) QGet(ctx context.Context, kind string, property string, val
 // entitySlicePtr must be a pointer to a slice of structs e.g. &[]struct{}
 // returns a slice of keys which relate to the returned entities
 func (client Client) QueryParent(ctx context.Context, kind string, parent *datastore.Key, entitySlicePtr interface{}) ([]*datastore.Key, error) {
-	p := reflect.ValueOf(entitySlicePtr)
-	slice := p.Elem()
-	elemType := slice.Type().Elem()
-
 	query := datastore.NewQuery(kind).Ancestor(parent)
-	it := client.ds.Run(ctx, query)
+	return getAll(client.ds.Run(ctx, query), entitySlicePtr)
+}
+
+// QueryProperty will get all Entities of a Kind with a property matching value
+// entitySlicePtr must be a pointer to a slice of structs e.g. &[]struct{}
+// returns a slice of keys which relate to the returned entities
+func (client Client) QueryProperty(ctx context.Context, kind string, property string, value string, entitySlicePtr interface{}) ([]*datastore.Key, error) {
+	query := datastore.NewQuery(kind).Filter(fmt.Sprintf("%s =", property), value)
+	return getAll(client.ds.Run(ctx, query), entitySlicePtr)
+}
+
+// appends every result of it to the slice pointed to by entitySlicePtr
+func getAll(it *datastore.Iterator, entitySlicePtr interface{}) ([]*datastore.Key, error) {
+	slice := reflect.ValueOf(entitySlicePtr).Elem()
+	elemType := slice.Type().Elem()
 
 	var keys []*datastore.Key
 	for {